@@ -34,6 +34,8 @@ func main() {
 				fmt.Println("Error: ", err)
 			}
 
+		} else if parseErr, ok := err.(*algebra.ParseError); ok {
+			fmt.Println(parseErr.Diagnostic())
 		} else {
 			fmt.Println("Error: ", err)
 		}
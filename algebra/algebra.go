@@ -16,6 +16,19 @@ const (
 	OP_MED             = 8
 	OP_HIGH            = 9
 	EQUALS             = 10
+
+	// Unary minus isn't a token type, as it's the same token as binary
+	// minus, but the parser gives it its own node with the operand in Left.
+	NEGATE = 11
+
+	// Commas separate the arguments of functions that take more than one.
+	// Their arguments are a chain of COMMA nodes in Left, so f(a, b, c) has
+	// Left ,(a, ,(b, c)). See Args.
+	COMMA = 12
+
+	// |x| is another way of writing abs(x). The bars are only a token type:
+	// the parser turns them into an abs node.
+	BAR = 13
 )
 
 type Expression struct {
@@ -48,17 +61,8 @@ func (e *Expression) String() string {
 	return out
 }
 
+// UnTree writes the expression back out as something Parse can read, with
+// only the brackets it needs. See Format for the options.
 func (e *Expression) UnTree() string {
-	switch e.Type {
-	case NUMBER, VARIABLE, CONSTANT, EQUALS:
-		return e.Op
-
-	case OP_LOW, OP_MED, OP_HIGH:
-		return "(" + e.Left.UnTree() + " " + e.Op + " " + e.Right.UnTree() + ")"
-
-	case FUNC_PREFIX:
-		return e.Op + "(" + e.Left.UnTree() + ")"
-	}
-
-	return "CAN'T UNTREE: " + e.Op
+	return e.Format(FormatOptions{})
 }
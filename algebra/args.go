@@ -0,0 +1,126 @@
+package algebra
+
+import (
+	"math"
+)
+
+// funcArities gives the smallest and largest number of arguments taken by
+// the functions that can take more than one, with -1 meaning there's no
+// limit. Every other function takes exactly one.
+var funcArities = map[string][2]int{
+	"log":   {1, 2},
+	"atan2": {2, 2},
+	"min":   {2, -1},
+	"max":   {2, -1},
+	"root":  {2, 2},
+	"hypot": {2, -1},
+}
+
+func funcArity(name string) (least, most int) {
+	if arity, ok := funcArities[canonicalFunc(name)]; ok {
+		return arity[0], arity[1]
+	}
+	return 1, 1
+}
+
+// Args gives the arguments of a prefix function. That's just Left for
+// functions of one argument, otherwise it's everything in the chain of
+// COMMA nodes there.
+func (exp *Expression) Args() []*Expression {
+	args := []*Expression{}
+	e := exp.Left
+	for e.Type == COMMA {
+		args = append(args, e.Left)
+		e = e.Right
+	}
+	return append(args, e)
+}
+
+// argList chains args together with COMMA nodes, ready to go in a
+// function's Left.
+func argList(args []*Expression) *Expression {
+	e := args[len(args)-1]
+	for i := len(args) - 2; i >= 0; i-- {
+		e = &Expression{",", COMMA, args[i], e}
+	}
+	return e
+}
+
+// realMultiFuncs are the functions of more than one argument. log(b, x) is
+// the log of x to base b, and root(n, x) is the nth root of x.
+var realMultiFuncs = map[string]func([]float64) float64{
+	"log":   func(x []float64) float64 { return math.Log(x[1]) / math.Log(x[0]) },
+	"atan2": func(x []float64) float64 { return math.Atan2(x[0], x[1]) },
+	"root":  func(x []float64) float64 { return realRoot(x[0], x[1]) },
+
+	"min": func(x []float64) float64 {
+		m := x[0]
+		for _, y := range x[1:] {
+			m = math.Min(m, y)
+		}
+		return m
+	},
+	"max": func(x []float64) float64 {
+		m := x[0]
+		for _, y := range x[1:] {
+			m = math.Max(m, y)
+		}
+		return m
+	},
+	"hypot": func(x []float64) float64 {
+		h := x[0]
+		for _, y := range x[1:] {
+			h = math.Hypot(h, y)
+		}
+		return h
+	},
+}
+
+var realMultiDomains = map[string]func([]float64) bool{
+	"log":   func(x []float64) bool { return x[0] > 0 && x[0] != 1 && x[1] > 0 },
+	"atan2": func(x []float64) bool { return x[0] != 0 || x[1] != 0 },
+	"root":  func(x []float64) bool { return rootDefined(x[0], x[1]) },
+}
+
+// extremes gives the positions of the arguments that min or max picks out
+// of x, of which there's more than one where they tie
+func extremes(name string, x []float64) []int {
+	m := realMultiFuncs[name](x)
+	picked := []int{}
+	for i, y := range x {
+		if y == m {
+			picked = append(picked, i)
+		}
+	}
+	return picked
+}
+
+// realRoot works out the nth root of x. Odd roots of negative numbers are
+// real, so root(3, -8) is -2 rather than undefined like (-8)^(1/3).
+func realRoot(n, x float64) float64 {
+	switch {
+	case n == 2:
+		return math.Sqrt(x)
+	case n == 3:
+		return math.Cbrt(x)
+	case x < 0 && isOddInteger(n):
+		return -math.Pow(-x, 1/n)
+	}
+	return math.Pow(x, 1/n)
+}
+
+func rootDefined(n, x float64) bool {
+	switch {
+	case n == 0:
+		return false
+	case x == 0:
+		return n > 0
+	case x < 0:
+		return isOddInteger(n)
+	}
+	return true
+}
+
+func isOddInteger(n float64) bool {
+	return math.Abs(math.Mod(n, 2)) == 1
+}
@@ -0,0 +1,76 @@
+package algebra
+
+import "testing"
+
+func TestMinMaxDerivatives(t *testing.T) {
+	for _, test := range []struct {
+		in     string
+		x, y   float64
+		dx, dy float64
+	}{
+		{"min(x, y)", 1, 2, 1, 0},
+		{"min(x, y)", 3, 2, 0, 1},
+		{"max(x, y)", 1, 2, 0, 1},
+		{"max(x^2, y, 1)", 3, 2, 6, 0},
+		{"max(x^2, y, 1)", 0.5, 0.2, 0, 0},
+		{"min(x*y, 2y, x)", 3, 1, 0, 2},
+	} {
+		e, err := Parse(test.in)
+		if err != nil {
+			t.Fatal(test.in, err)
+		}
+		env := map[string]float64{"x": test.x, "y": test.y}
+
+		for _, v := range []struct {
+			name string
+			want float64
+		}{{"x", test.dx}, {"y", test.dy}} {
+			d, err := e.Differentiate(v.name)
+			if err != nil {
+				t.Fatalf("d/d%s %s: %v", v.name, test.in, err)
+			}
+			if got, err := d.Eval(env); err != nil || !approxEqual(got, v.want) {
+				t.Errorf("d/d%s %s at %v: got %v, %v want %v", v.name, test.in, env, got, err, v.want)
+			}
+			if _, got, err := e.EvalDerivative(env, v.name); err != nil || got != v.want {
+				t.Errorf("EvalDerivative d/d%s %s at %v: got %v, %v want %v", v.name, test.in, env, got, err, v.want)
+			}
+		}
+
+		_, grad, err := e.EvalGradient(env)
+		if err != nil || grad["x"] != test.dx || grad["y"] != test.dy {
+			t.Errorf("EvalGradient %s at %v: got %v, %v", test.in, env, grad, err)
+		}
+	}
+}
+
+func TestMinMaxTies(t *testing.T) {
+	e, _ := Parse("min(x, y)")
+	env := map[string]float64{"x": 2, "y": 2}
+
+	d, err := e.Differentiate("x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Eval(env); err == nil {
+		t.Error("Differentiate: no error")
+	}
+	if _, _, err := e.EvalDerivative(env, "x"); err == nil {
+		t.Error("EvalDerivative: no error")
+	}
+	if _, _, err := e.EvalGradient(env); err == nil {
+		t.Error("EvalGradient: no error")
+	}
+
+	// Moving along the line where they tie, min's changing at the same rate
+	d2, err := e.EvalDual(map[string]Dual{"x": {2, 1}, "y": {2, 1}})
+	if err != nil || d2.Deriv != 1 {
+		t.Errorf("EvalDual: got %v, %v", d2, err)
+	}
+
+	// Arguments that tie without depending on x don't matter
+	e, _ = Parse("max(1, 1, x)")
+	if _, got, err := e.EvalDerivative(map[string]float64{"x": 0}, "x"); err != nil || got != 0 {
+		t.Errorf("max(1, 1, x): got %v, %v", got, err)
+	}
+}
@@ -0,0 +1,240 @@
+package algebra
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// batchChunkSize is how many points are worked on at once. It's small enough
+// that the intermediate results for a chunk stay in the cache.
+const batchChunkSize = 1024
+
+// EvalBatch evaluates the expression at every point in a set of columns:
+// the i'th result uses the i'th value of each variable in env, which all
+// need to be the same length. Each node of the tree is visited once per
+// chunk of points rather than once per point.
+//
+// Like Compile, domains aren't checked, so points where the expression is
+// undefined come out as NaN or ±Inf.
+func (exp *Expression) EvalBatch(env map[string][]float64) ([]float64, error) {
+	return exp.EvalBatchParallel(env, 1)
+}
+
+// EvalBatchParallel is EvalBatch, but splits the chunks of points between
+// workers goroutines.
+func (exp *Expression) EvalBatchParallel(env map[string][]float64, workers int) ([]float64, error) {
+	n := -1
+	for _, col := range env {
+		if n != -1 && len(col) != n {
+			return nil, errors.New("Columns aren't all the same length")
+		}
+		n = len(col)
+	}
+	if n == -1 {
+		n = 0
+	}
+
+	// With no points there are no chunks to find any errors, like unbound
+	// variables, so walk the tree once with nothing in it
+	if n == 0 {
+		if _, err := exp.evalBatch(env, 0, 0); err != nil {
+			return nil, err
+		}
+	}
+
+	out := make([]float64, n)
+	chunks := (n + batchChunkSize - 1) / batchChunkSize
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > chunks {
+		workers = chunks
+	}
+
+	var (
+		next     int64 = -1
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				chunk := int(atomic.AddInt64(&next, 1))
+				if chunk >= chunks {
+					return
+				}
+
+				lo := chunk * batchChunkSize
+				hi := lo + batchChunkSize
+				if hi > n {
+					hi = n
+				}
+
+				res, err := exp.evalBatch(env, lo, hi)
+				if err != nil {
+					errOnce.Do(func() { firstErr = err })
+					return
+				}
+				copy(out[lo:hi], res)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return out, nil
+}
+
+// evalBatch works out the expression for the points from lo up to hi. The
+// slice it returns is always freshly allocated, so the caller can reuse it
+// for its own result.
+func (exp *Expression) evalBatch(env map[string][]float64, lo, hi int) ([]float64, error) {
+	op := exp.Op
+
+	switch exp.Type {
+	case NUMBER:
+		x, err := strconv.ParseFloat(op, 64)
+		if err != nil {
+			return nil, err
+		}
+		return fillBatch(x, hi-lo), nil
+
+	case CONSTANT:
+		if x, ok := realConstants[op]; ok {
+			return fillBatch(x, hi-lo), nil
+		}
+		return nil, errors.New("Constant has no real value: " + op)
+
+	case VARIABLE:
+		col, ok := env[op]
+		if !ok {
+			return nil, &UnboundVariableError{op}
+		}
+		return append([]float64(nil), col[lo:hi]...), nil
+
+	case OP_LOW, OP_MED, OP_HIGH:
+		a, err := exp.Left.evalBatch(env, lo, hi)
+		if err != nil {
+			return nil, err
+		}
+		b, err := exp.Right.evalBatch(env, lo, hi)
+		if err != nil {
+			return nil, err
+		}
+
+		switch op {
+		case "+":
+			for i := range a {
+				a[i] += b[i]
+			}
+		case "-":
+			for i := range a {
+				a[i] -= b[i]
+			}
+		case "*":
+			for i := range a {
+				a[i] *= b[i]
+			}
+		case "/":
+			for i := range a {
+				a[i] /= b[i]
+			}
+		case "^":
+			for i := range a {
+				a[i] = math.Pow(a[i], b[i])
+			}
+		default:
+			return nil, errors.New("Unknown operator: " + op)
+		}
+		return a, nil
+
+	case FUNC_PREFIX:
+		if exp.Left.Type == COMMA {
+			fn, ok := realMultiFuncs[canonicalFunc(op)]
+			if !ok {
+				return nil, errors.New("Unknown function: " + op)
+			}
+
+			args := exp.Args()
+			cols := make([][]float64, len(args))
+			for i, arg := range args {
+				var err error
+				if cols[i], err = arg.evalBatch(env, lo, hi); err != nil {
+					return nil, err
+				}
+			}
+
+			x := make([]float64, len(args))
+			for i := range cols[0] {
+				for j, col := range cols {
+					x[j] = col[i]
+				}
+				cols[0][i] = fn(x)
+			}
+			return cols[0], nil
+		}
+
+		fn, ok := realFuncs[canonicalFunc(op)]
+		if !ok {
+			return nil, errors.New("Unknown function: " + op)
+		}
+
+		a, err := exp.Left.evalBatch(env, lo, hi)
+		if err != nil {
+			return nil, err
+		}
+		for i := range a {
+			a[i] = fn(a[i])
+		}
+		return a, nil
+
+	case NEGATE:
+		a, err := exp.Left.evalBatch(env, lo, hi)
+		if err != nil {
+			return nil, err
+		}
+		for i := range a {
+			a[i] = -a[i]
+		}
+		return a, nil
+
+	case FUNC_POSTFIX:
+		if op != "!" {
+			return nil, errors.New("Unknown function: " + op)
+		}
+
+		a, err := exp.Left.evalBatch(env, lo, hi)
+		if err != nil {
+			return nil, err
+		}
+		for i, x := range a {
+			if factorialDefined(x) {
+				a[i] = factorial(x)
+			} else {
+				a[i] = math.NaN()
+			}
+		}
+		return a, nil
+
+	case EQUALS:
+		return nil, errors.New("Can't evaluate an equation")
+	}
+
+	return nil, errors.New("Unknown operator: " + op)
+}
+
+func fillBatch(x float64, n int) []float64 {
+	a := make([]float64, n)
+	for i := range a {
+		a[i] = x
+	}
+	return a
+}
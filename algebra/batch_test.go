@@ -0,0 +1,106 @@
+package algebra
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestEvalBatchUnbound(t *testing.T) {
+	e, err := Parse("x + y")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, env := range []map[string][]float64{
+		{},
+		{"x": {}},
+		{"x": {1, 2, 3}},
+		{"x": make([]float64, 3*batchChunkSize)},
+	} {
+		for _, workers := range []int{1, 4} {
+			var unbound *UnboundVariableError
+			if _, err := e.EvalBatchParallel(env, workers); !errors.As(err, &unbound) {
+				t.Errorf("%d columns of %d with %d workers: got %v, want an unbound variable", len(env), len(env["x"]), workers, err)
+			}
+		}
+	}
+
+	// Other errors are found with no points too
+	for _, s := range []string{"i + x", "x = 1"} {
+		e, err := Parse(s)
+		if err != nil {
+			t.Fatal(s, err)
+		}
+		if _, err := e.EvalBatch(map[string][]float64{"x": {}}); err == nil {
+			t.Errorf("%s: expected an error", s)
+		}
+	}
+}
+
+func TestEvalBatchEmpty(t *testing.T) {
+	e, err := Parse("x + 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := e.EvalBatch(map[string][]float64{"x": {}})
+	if err != nil || len(got) != 0 {
+		t.Errorf("got %v, %v want []", got, err)
+	}
+}
+
+func TestEvalBatchLengths(t *testing.T) {
+	e, err := Parse("x + y")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.EvalBatch(map[string][]float64{"x": {1, 2}, "y": {1, 2, 3}}); err == nil {
+		t.Error("expected an error for columns of different lengths")
+	}
+	if _, err := e.EvalBatch(map[string][]float64{"x": {}, "y": {1}}); err == nil {
+		t.Error("expected an error for an empty and a non-empty column")
+	}
+}
+
+// Running in parallel should give exactly the same numbers, and those
+// should be the same as Eval's where it's defined.
+func TestEvalBatchParallel(t *testing.T) {
+	n := 5*batchChunkSize + 17
+	xs, ys := make([]float64, n), make([]float64, n)
+	for i := range xs {
+		xs[i] = float64(i)/100 - 20
+		ys[i] = math.Sin(float64(i))
+	}
+	env := map[string][]float64{"x": xs, "y": ys}
+
+	for _, s := range dualExpressions {
+		e, err := Parse(s)
+		if err != nil {
+			t.Fatal(s, err)
+		}
+		want, err := e.EvalBatch(env)
+		if err != nil {
+			t.Fatal(s, err)
+		}
+
+		for _, workers := range []int{0, 2, 3, 16} {
+			got, err := e.EvalBatchParallel(env, workers)
+			if err != nil {
+				t.Fatal(s, err)
+			}
+			for i := range want {
+				if got[i] != want[i] && !(math.IsNaN(got[i]) && math.IsNaN(want[i])) {
+					t.Errorf("%s with %d workers at %d: got %v want %v", s, workers, i, got[i], want[i])
+					break
+				}
+			}
+		}
+
+		for i := 0; i < n; i += 101 {
+			v, err := e.Eval(map[string]float64{"x": xs[i], "y": ys[i]})
+			if err == nil && !approxEqual(want[i], v) {
+				t.Errorf("%s at (%v, %v): got %v, Eval gives %v", s, xs[i], ys[i], want[i], v)
+			}
+		}
+	}
+}
@@ -0,0 +1,488 @@
+package algebra
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"strconv"
+)
+
+// Program is an expression compiled down to bytecode for a little stack
+// machine. Unlike the closures from Compile, a Program can be written out
+// with MarshalBinary and read back in with UnmarshalBinary, so compiled
+// expressions can be cached without having to parse (and differentiate)
+// them all over again.
+type Program struct {
+	Vars   []string
+	Consts []float64
+	Funcs  []string
+	Code   []byte
+
+	fns      []func(float64) float64
+	multiFns []func([]float64) float64
+	maxStack int
+}
+
+// Each instruction is an opcode byte. opPush, opLoad and opCall are followed
+// by a uvarint index into Consts, the variables or Funcs. opCallN, for
+// functions of more than one argument, is followed by an index into Funcs
+// and then the number of arguments.
+const (
+	opPush byte = iota
+	opLoad
+	opAdd
+	opSub
+	opMul
+	opDiv
+	opPow
+	opCall
+	opFact
+	opNeg
+	opCallN
+)
+
+var binaryOpcodes = map[string]byte{
+	"+": opAdd,
+	"-": opSub,
+	"*": opMul,
+	"/": opDiv,
+	"^": opPow,
+}
+
+// bytecodeMagic starts every marshalled Program. The last byte is the
+// format version.
+var bytecodeMagic = []byte("ALGB\x01")
+
+// CompileBytecode compiles exp to a Program taking the variables in vars,
+// in that order. Like Compile, constant subexpressions are worked out ahead
+// of time and domains aren't checked when the Program is run.
+func CompileBytecode(exp *Expression, vars []string) (*Program, error) {
+	c := &bytecodeCompiler{
+		prog:   &Program{Vars: vars},
+		slots:  make(map[string]int, len(vars)),
+		consts: make(map[float64]int),
+		funcs:  make(map[string]int),
+	}
+	for i, v := range vars {
+		c.slots[v] = i
+	}
+
+	if err := c.compile(exp); err != nil {
+		return nil, err
+	}
+	if err := c.prog.link(); err != nil {
+		return nil, err
+	}
+	return c.prog, nil
+}
+
+type bytecodeCompiler struct {
+	prog   *Program
+	slots  map[string]int
+	consts map[float64]int
+	funcs  map[string]int
+}
+
+func (c *bytecodeCompiler) emit(op byte, arg int) {
+	c.prog.Code = append(c.prog.Code, op)
+	switch op {
+	case opPush, opLoad, opCall:
+		c.prog.Code = binary.AppendUvarint(c.prog.Code, uint64(arg))
+	}
+}
+
+func (c *bytecodeCompiler) function(name string) int {
+	i, ok := c.funcs[name]
+	if !ok {
+		i = len(c.prog.Funcs)
+		c.funcs[name] = i
+		c.prog.Funcs = append(c.prog.Funcs, name)
+	}
+	return i
+}
+
+func (c *bytecodeCompiler) push(x float64) {
+	i, ok := c.consts[x]
+	if !ok {
+		i = len(c.prog.Consts)
+		c.consts[x] = i
+		c.prog.Consts = append(c.prog.Consts, x)
+	}
+	c.emit(opPush, i)
+}
+
+func (c *bytecodeCompiler) compile(exp *Expression) error {
+	op := exp.Op
+
+	if exp.Type != NUMBER && exp.IsConstant() {
+		if x, err := exp.Eval(nil); err == nil {
+			c.push(x)
+			return nil
+		}
+	}
+
+	switch exp.Type {
+	case NUMBER:
+		x, err := strconv.ParseFloat(op, 64)
+		if err != nil {
+			return err
+		}
+		c.push(x)
+		return nil
+
+	case CONSTANT:
+		if x, ok := realConstants[op]; ok {
+			c.push(x)
+			return nil
+		}
+		return errors.New("Constant has no real value: " + op)
+
+	case VARIABLE:
+		i, ok := c.slots[op]
+		if !ok {
+			return &UnboundVariableError{op}
+		}
+		c.emit(opLoad, i)
+		return nil
+
+	case OP_LOW, OP_MED, OP_HIGH:
+		code, ok := binaryOpcodes[op]
+		if !ok {
+			return errors.New("Unknown operator: " + op)
+		}
+		if err := c.compile(exp.Left); err != nil {
+			return err
+		}
+		if err := c.compile(exp.Right); err != nil {
+			return err
+		}
+		c.emit(code, 0)
+		return nil
+
+	case FUNC_PREFIX:
+		name := canonicalFunc(op)
+
+		if exp.Left.Type == COMMA {
+			if _, ok := realMultiFuncs[name]; !ok {
+				return errors.New("Unknown function: " + op)
+			}
+			args := exp.Args()
+			for _, arg := range args {
+				if err := c.compile(arg); err != nil {
+					return err
+				}
+			}
+
+			c.prog.Code = append(c.prog.Code, opCallN)
+			c.prog.Code = binary.AppendUvarint(c.prog.Code, uint64(c.function(name)))
+			c.prog.Code = binary.AppendUvarint(c.prog.Code, uint64(len(args)))
+			return nil
+		}
+
+		if _, ok := realFuncs[name]; !ok {
+			return errors.New("Unknown function: " + op)
+		}
+		if err := c.compile(exp.Left); err != nil {
+			return err
+		}
+		c.emit(opCall, c.function(name))
+		return nil
+
+	case NEGATE:
+		if err := c.compile(exp.Left); err != nil {
+			return err
+		}
+		c.emit(opNeg, 0)
+		return nil
+
+	case FUNC_POSTFIX:
+		if op != "!" {
+			return errors.New("Unknown function: " + op)
+		}
+		if err := c.compile(exp.Left); err != nil {
+			return err
+		}
+		c.emit(opFact, 0)
+		return nil
+
+	case EQUALS:
+		return errors.New("Can't evaluate an equation")
+	}
+
+	return errors.New("Unknown operator: " + op)
+}
+
+// link looks up the program's functions and checks that its code is sane,
+// so that Run doesn't have to: every index is in range, the stack never
+// underflows, and there's exactly one value left on it at the end.
+func (p *Program) link() error {
+	// log is in both tables, so a function only has to be in one of them
+	// here, and the opcodes check they're calling the right kind
+	p.fns = make([]func(float64) float64, len(p.Funcs))
+	p.multiFns = make([]func([]float64) float64, len(p.Funcs))
+	for i, name := range p.Funcs {
+		p.fns[i] = realFuncs[name]
+		p.multiFns[i] = realMultiFuncs[name]
+		if p.fns[i] == nil && p.multiFns[i] == nil {
+			return errors.New("Unknown function: " + name)
+		}
+	}
+
+	depth := 0
+	p.maxStack = 0
+	for pc := 0; pc < len(p.Code); {
+		op := p.Code[pc]
+		pc++
+
+		switch op {
+		case opPush, opLoad, opCall:
+			arg, n := binary.Uvarint(p.Code[pc:])
+			if n <= 0 {
+				return errors.New("Bad bytecode: truncated argument")
+			}
+			pc += n
+
+			limit := len(p.Consts)
+			switch op {
+			case opLoad:
+				limit = len(p.Vars)
+			case opCall:
+				limit = len(p.Funcs)
+			}
+			if arg >= uint64(limit) {
+				return errors.New("Bad bytecode: argument out of range")
+			}
+
+			if op == opCall {
+				if p.fns[arg] == nil {
+					return errors.New("Bad bytecode: " + p.Funcs[arg] + " needs more than one argument")
+				}
+				if depth < 1 {
+					return errors.New("Bad bytecode: stack underflow")
+				}
+			} else {
+				depth++
+			}
+
+		case opCallN:
+			fn, n := binary.Uvarint(p.Code[pc:])
+			if n <= 0 {
+				return errors.New("Bad bytecode: truncated argument")
+			}
+			pc += n
+			nargs, n := binary.Uvarint(p.Code[pc:])
+			if n <= 0 {
+				return errors.New("Bad bytecode: truncated argument")
+			}
+			pc += n
+
+			if fn >= uint64(len(p.Funcs)) {
+				return errors.New("Bad bytecode: argument out of range")
+			}
+			name := p.Funcs[fn]
+			if least, most := funcArity(name); p.multiFns[fn] == nil || nargs < uint64(least) || (most != -1 && nargs > uint64(most)) {
+				return errors.New("Bad bytecode: wrong number of arguments for " + name)
+			}
+			if uint64(depth) < nargs {
+				return errors.New("Bad bytecode: stack underflow")
+			}
+			depth -= int(nargs) - 1
+
+		case opAdd, opSub, opMul, opDiv, opPow:
+			if depth < 2 {
+				return errors.New("Bad bytecode: stack underflow")
+			}
+			depth--
+
+		case opFact, opNeg:
+			if depth < 1 {
+				return errors.New("Bad bytecode: stack underflow")
+			}
+
+		default:
+			return errors.New("Bad bytecode: unknown opcode " + strconv.Itoa(int(op)))
+		}
+
+		if depth > p.maxStack {
+			p.maxStack = depth
+		}
+	}
+
+	if depth != 1 {
+		return errors.New("Bad bytecode: doesn't leave one value on the stack")
+	}
+	return nil
+}
+
+// Run runs the program with the variables set to vals, which should be in
+// the same order as p.Vars.
+func (p *Program) Run(vals []float64) float64 {
+	// Most expressions only need a shallow stack, so it can usually live on
+	// the Go stack rather than the heap.
+	var buf [32]float64
+	stack := buf[:0]
+	if p.maxStack > len(buf) {
+		stack = make([]float64, 0, p.maxStack)
+	}
+
+	code := p.Code
+	for pc := 0; pc < len(code); {
+		op := code[pc]
+		pc++
+
+		var arg uint64
+		switch op {
+		case opPush, opLoad, opCall, opCallN:
+			var n int
+			arg, n = binary.Uvarint(code[pc:])
+			pc += n
+		}
+
+		top := len(stack) - 1
+		switch op {
+		case opPush:
+			stack = append(stack, p.Consts[arg])
+		case opLoad:
+			stack = append(stack, vals[arg])
+		case opCall:
+			stack[top] = p.fns[arg](stack[top])
+		case opFact:
+			if x := stack[top]; factorialDefined(x) {
+				stack[top] = factorial(x)
+			} else {
+				stack[top] = math.NaN()
+			}
+		case opNeg:
+			stack[top] = -stack[top]
+		case opCallN:
+			nargs, n := binary.Uvarint(code[pc:])
+			pc += n
+			base := len(stack) - int(nargs)
+			stack[base] = p.multiFns[arg](stack[base:])
+			stack = stack[:base+1]
+
+		default:
+			a, b := stack[top-1], stack[top]
+			stack = stack[:top]
+			switch op {
+			case opAdd:
+				stack[top-1] = a + b
+			case opSub:
+				stack[top-1] = a - b
+			case opMul:
+				stack[top-1] = a * b
+			case opDiv:
+				stack[top-1] = a / b
+			case opPow:
+				stack[top-1] = math.Pow(a, b)
+			}
+		}
+	}
+
+	return stack[0]
+}
+
+// MarshalBinary encodes the program as the magic bytes, then the variable
+// names, the constants, the function names and finally the code, each
+// prefixed with its length as a uvarint.
+func (p *Program) MarshalBinary() ([]byte, error) {
+	buf := append([]byte{}, bytecodeMagic...)
+
+	buf = appendStrings(buf, p.Vars)
+
+	buf = binary.AppendUvarint(buf, uint64(len(p.Consts)))
+	for _, x := range p.Consts {
+		buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(x))
+	}
+
+	buf = appendStrings(buf, p.Funcs)
+
+	buf = binary.AppendUvarint(buf, uint64(len(p.Code)))
+	buf = append(buf, p.Code...)
+
+	return buf, nil
+}
+
+// UnmarshalBinary decodes a program written by MarshalBinary, checking it's
+// safe to run.
+func (p *Program) UnmarshalBinary(data []byte) error {
+	if !bytes.HasPrefix(data, bytecodeMagic) {
+		return errors.New("Bad bytecode: not a compiled expression, or from a different version")
+	}
+	r := &byteReader{data: data[len(bytecodeMagic):]}
+
+	vars := r.strings()
+
+	consts := make([]float64, r.length(8))
+	for i := range consts {
+		consts[i] = math.Float64frombits(binary.LittleEndian.Uint64(r.next(8)))
+	}
+
+	funcs := r.strings()
+	code := r.next(r.length(1))
+
+	if r.err != nil {
+		return r.err
+	}
+	if len(r.data) != 0 {
+		return errors.New("Bad bytecode: trailing data")
+	}
+
+	*p = Program{Vars: vars, Consts: consts, Funcs: funcs, Code: code}
+	return p.link()
+}
+
+func appendStrings(buf []byte, strs []string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(strs)))
+	for _, s := range strs {
+		buf = binary.AppendUvarint(buf, uint64(len(s)))
+		buf = append(buf, s...)
+	}
+	return buf
+}
+
+// byteReader reads the pieces of a marshalled Program. Once it hits an
+// error it just returns zero values, so the error only needs checking at
+// the end.
+type byteReader struct {
+	data []byte
+	err  error
+}
+
+func (r *byteReader) fail() {
+	if r.err == nil {
+		r.err = errors.New("Bad bytecode: truncated")
+	}
+	r.data = nil
+}
+
+func (r *byteReader) next(n int) []byte {
+	if n > len(r.data) {
+		r.fail()
+		return make([]byte, n)
+	}
+	b := r.data[:n:n]
+	r.data = r.data[n:]
+	return b
+}
+
+// length reads a uvarint count of items that are each at least size bytes
+// long, checking there's enough data left for them all.
+func (r *byteReader) length(size int) int {
+	n, k := binary.Uvarint(r.data)
+	if k <= 0 || n > uint64(len(r.data)-k)/uint64(size) {
+		r.fail()
+		return 0
+	}
+	r.data = r.data[k:]
+	return int(n)
+}
+
+func (r *byteReader) strings() []string {
+	strs := make([]string, r.length(1))
+	for i := range strs {
+		strs[i] = string(r.next(r.length(1)))
+	}
+	return strs
+}
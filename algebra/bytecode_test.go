@@ -0,0 +1,109 @@
+package algebra
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBytecodeRoundTrip(t *testing.T) {
+	exps := append([]string{benchExpression, "x!", "-x", "2"}, dualExpressions...)
+	for name := range realFuncs {
+		exps = append(exps, name+"(x*y + 0.5)")
+	}
+
+	for _, s := range exps {
+		e, err := Parse(s)
+		if err != nil {
+			t.Fatal(s, err)
+		}
+		prog, err := CompileBytecode(e, []string{"x", "y"})
+		if err != nil {
+			t.Fatal(s, err)
+		}
+		data, err := prog.MarshalBinary()
+		if err != nil {
+			t.Fatal(s, err)
+		}
+
+		var got Program
+		if err := got.UnmarshalBinary(data); err != nil {
+			t.Fatalf("%s: %v", s, err)
+		}
+		if again, _ := got.MarshalBinary(); !bytes.Equal(again, data) {
+			t.Errorf("%s: marshalled differently after a round trip", s)
+		}
+
+		for _, p := range dualPoints {
+			want, err := e.Eval(map[string]float64{"x": p[0], "y": p[1]})
+			if err != nil {
+				continue
+			}
+			if v := got.Run(p[:]); !approxEqual(v, want) {
+				t.Errorf("%s at %v: got %v want %v", s, p, v, want)
+			}
+		}
+	}
+}
+
+func TestBytecodeRejects(t *testing.T) {
+	for name, prog := range map[string]Program{
+		"unknown opcode":     {Consts: []float64{1}, Code: []byte{opPush, 0, 99}},
+		"variable too big":   {Vars: []string{"x"}, Code: []byte{opLoad, 1}},
+		"constant too big":   {Consts: []float64{1}, Code: []byte{opPush, 1}},
+		"function too big":   {Consts: []float64{1}, Funcs: []string{"sin"}, Code: []byte{opPush, 0, opCall, 1}},
+		"huge index":         {Vars: []string{"x"}, Code: []byte{opLoad, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}},
+		"truncated argument": {Vars: []string{"x"}, Code: []byte{opLoad, 0x80}},
+		"unknown function":   {Consts: []float64{1}, Funcs: []string{"foo"}, Code: []byte{opPush, 0, opCall, 0}},
+		"wrong arity":        {Consts: []float64{1}, Funcs: []string{"atan2"}, Code: []byte{opPush, 0, opCall, 0}},
+		"too many arguments": {Consts: []float64{1}, Funcs: []string{"atan2"}, Code: []byte{opPush, 0, opPush, 0, opPush, 0, opCallN, 0, 3}},
+		"underflow":          {Consts: []float64{1}, Code: []byte{opPush, 0, opAdd}},
+		"leftovers":          {Consts: []float64{1}, Code: []byte{opPush, 0, opPush, 0}},
+		"empty":              {},
+	} {
+		data, err := prog.MarshalBinary()
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		if err := new(Program).UnmarshalBinary(data); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+
+	e, err := Parse("atan2(y, x) + sin(x)^2 - 3!")
+	if err != nil {
+		t.Fatal(err)
+	}
+	prog, err := CompileBytecode(e, []string{"x", "y"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := prog.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range data {
+		if err := new(Program).UnmarshalBinary(data[:i]); err == nil {
+			t.Errorf("truncated to %d bytes: no error", i)
+		}
+	}
+	if err := new(Program).UnmarshalBinary(append(data, 0)); err == nil {
+		t.Error("trailing data: no error")
+	}
+	if err := new(Program).UnmarshalBinary(append([]byte("ALGB\x02"), data[5:]...)); err == nil {
+		t.Error("wrong version: no error")
+	}
+
+	// Whatever gets corrupted, anything that's accepted has to be safe to
+	// run
+	for i := range data {
+		for _, b := range []byte{0, 1, 2, 7, 10, 11, 0x7f, 0x80, 0xff} {
+			bad := append([]byte{}, data...)
+			bad[i] = b
+			var p Program
+			if p.UnmarshalBinary(bad) == nil {
+				p.Run(make([]float64, len(p.Vars)))
+			}
+		}
+	}
+}
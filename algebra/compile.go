@@ -0,0 +1,151 @@
+package algebra
+
+import (
+	"errors"
+	"math"
+	"strconv"
+)
+
+// Compile turns exp into a Go function of the variables in vars, for when
+// the same expression needs evaluating over and over. The function takes
+// the variables' values in the same order as vars.
+//
+// Everything that can be worked out ahead of time is: the operators and
+// functions are looked up, variables are given slots, and any constant
+// subexpressions are evaluated. Unlike Eval the compiled function doesn't
+// check domains, so ln(-1) or 1/0 give NaN or ±Inf as in the math package.
+func Compile(exp *Expression, vars []string) (func([]float64) float64, error) {
+	slots := make(map[string]int, len(vars))
+	for i, v := range vars {
+		slots[v] = i
+	}
+	return exp.compile(slots)
+}
+
+func (exp *Expression) compile(slots map[string]int) (func([]float64) float64, error) {
+	op := exp.Op
+
+	if exp.Type != NUMBER && exp.IsConstant() {
+		if x, err := exp.Eval(nil); err == nil {
+			return func([]float64) float64 { return x }, nil
+		}
+	}
+
+	switch exp.Type {
+	case NUMBER:
+		x, err := strconv.ParseFloat(op, 64)
+		if err != nil {
+			return nil, err
+		}
+		return func([]float64) float64 { return x }, nil
+
+	case CONSTANT:
+		if x, ok := realConstants[op]; ok {
+			return func([]float64) float64 { return x }, nil
+		}
+		return nil, errors.New("Constant has no real value: " + op)
+
+	case VARIABLE:
+		i, ok := slots[op]
+		if !ok {
+			return nil, &UnboundVariableError{op}
+		}
+		return func(v []float64) float64 { return v[i] }, nil
+
+	case OP_LOW, OP_MED, OP_HIGH:
+		f, err := exp.Left.compile(slots)
+		if err != nil {
+			return nil, err
+		}
+		g, err := exp.Right.compile(slots)
+		if err != nil {
+			return nil, err
+		}
+
+		switch op {
+		case "+":
+			return func(v []float64) float64 { return f(v) + g(v) }, nil
+		case "-":
+			return func(v []float64) float64 { return f(v) - g(v) }, nil
+		case "*":
+			return func(v []float64) float64 { return f(v) * g(v) }, nil
+		case "/":
+			return func(v []float64) float64 { return f(v) / g(v) }, nil
+		case "^":
+			// Squaring is common enough (especially in derivatives) to be
+			// worth skipping math.Pow for
+			if exp.Right.Type == NUMBER && exp.Right.Op == "2" {
+				return func(v []float64) float64 {
+					x := f(v)
+					return x * x
+				}, nil
+			}
+			return func(v []float64) float64 { return math.Pow(f(v), g(v)) }, nil
+		}
+		return nil, errors.New("Unknown operator: " + op)
+
+	case FUNC_PREFIX:
+		if exp.Left.Type == COMMA {
+			fn, ok := realMultiFuncs[canonicalFunc(op)]
+			if !ok {
+				return nil, errors.New("Unknown function: " + op)
+			}
+
+			args := exp.Args()
+			fs := make([]func([]float64) float64, len(args))
+			for i, arg := range args {
+				var err error
+				if fs[i], err = arg.compile(slots); err != nil {
+					return nil, err
+				}
+			}
+			return func(v []float64) float64 {
+				x := make([]float64, len(fs))
+				for i, f := range fs {
+					x[i] = f(v)
+				}
+				return fn(x)
+			}, nil
+		}
+
+		f, err := exp.Left.compile(slots)
+		if err != nil {
+			return nil, err
+		}
+
+		fn, ok := realFuncs[canonicalFunc(op)]
+		if !ok {
+			return nil, errors.New("Unknown function: " + op)
+		}
+		return func(v []float64) float64 { return fn(f(v)) }, nil
+
+	case NEGATE:
+		f, err := exp.Left.compile(slots)
+		if err != nil {
+			return nil, err
+		}
+		return func(v []float64) float64 { return -f(v) }, nil
+
+	case FUNC_POSTFIX:
+		f, err := exp.Left.compile(slots)
+		if err != nil {
+			return nil, err
+		}
+
+		if op != "!" {
+			return nil, errors.New("Unknown function: " + op)
+		}
+		return func(v []float64) float64 {
+			x := f(v)
+			if !factorialDefined(x) {
+				return math.NaN()
+			}
+			return factorial(x)
+		}, nil
+
+	case EQUALS:
+		return nil, errors.New("Can't evaluate an equation")
+	}
+
+	return nil, errors.New("Unknown operator: " + op)
+}
@@ -0,0 +1,68 @@
+package algebra
+
+import "testing"
+
+const benchExpression = "sin(x)^2 + cos(y)^2 + sqrt(x^2 + y^2) / (1 + e^(-x*y)) - 3x^3 + ln(2 + x*x)"
+
+func TestCompileMatchesEval(t *testing.T) {
+	e, err := Parse(benchExpression)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := Compile(e, []string{"x", "y"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range [][2]float64{{0, 0}, {1.5, -2}, {-3, 0.25}} {
+		want, err := e.Eval(map[string]float64{"x": p[0], "y": p[1]})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := f(p[:]); !approxEqual(got, want) {
+			t.Errorf("at %v: got %v want %v", p, got, want)
+		}
+	}
+}
+
+func BenchmarkEval(b *testing.B) {
+	e, err := Parse(benchExpression)
+	if err != nil {
+		b.Fatal(err)
+	}
+	env := map[string]float64{"x": 1.5, "y": -2}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		e.Eval(env)
+	}
+}
+
+func BenchmarkCompiled(b *testing.B) {
+	e, err := Parse(benchExpression)
+	if err != nil {
+		b.Fatal(err)
+	}
+	f, err := Compile(e, []string{"x", "y"})
+	if err != nil {
+		b.Fatal(err)
+	}
+	vals := []float64{1.5, -2}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		f(vals)
+	}
+}
+
+func BenchmarkCompile(b *testing.B) {
+	e, err := Parse(benchExpression)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		Compile(e, []string{"x", "y"})
+	}
+}
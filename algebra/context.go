@@ -0,0 +1,453 @@
+package algebra
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Context is a symbol table of functions and named expressions defined by
+// the user, like f(x) = x^2 + 1 or r = sqrt(x^2 + y^2). Expressions parsed
+// with the Context can use them, as f(2t) or r^2, and Inline swaps them
+// for their definitions when the expression needs evaluating.
+type Context struct {
+	opts  ParseOptions
+	funcs map[string]*Function
+	names map[string]*Expression
+}
+
+// Function is a function defined in a Context
+type Function struct {
+	Name   string
+	Params []string
+	Body   *Expression
+}
+
+// NewContext makes an empty Context, which parses everything with opts.
+func NewContext(opts ParseOptions) *Context {
+	return &Context{
+		opts:  opts,
+		funcs: make(map[string]*Function),
+		names: make(map[string]*Expression),
+	}
+}
+
+// Parse parses s like ParseWithOptions, also recognising the Context's
+// functions and names.
+func (c *Context) Parse(s string) (*Expression, error) {
+	return parseIn(s, c.opts, c.scope())
+}
+
+// Define parses a definition of a function, like f(x, y) = x^2 + y, or of
+// a name, like r = sqrt(x^2 + y^2), and adds it to the Context. Anything
+// already defined with the same name is replaced.
+func (c *Context) Define(def string) error {
+	eq := strings.IndexByte(def, '=')
+	if eq < 0 {
+		return &ParseError{Input: def, Offset: len(def), Msg: "Expected '='"}
+	}
+
+	name, params, sigErr := parseSignature(foldCase(def[:eq], c.opts))
+	if sigErr != nil {
+		sigErr.Input = def
+		return sigErr
+	}
+
+	// The name being defined is in scope for its own body, so that using
+	// it there is read as the same thing and caught as recursion, rather
+	// than as a variable: h(x) = h(x) isn't h*x. A parameter with the same
+	// name still hides it.
+	sc := c.scope()
+	if params == nil {
+		sc.add(name, VARIABLE)
+		delete(sc.arities, name)
+	} else {
+		sc.add(name, FUNC_PREFIX)
+		sc.arities[name] = len(params)
+	}
+	for _, p := range params {
+		sc.add(p, VARIABLE)
+		delete(sc.arities, p)
+	}
+
+	body, err := parse(foldCase(def[eq+1:], c.opts), c.opts, sc)
+	if err != nil {
+		if parseErr, ok := err.(*ParseError); ok {
+			parseErr.Input = def
+			parseErr.Offset += eq + 1
+		}
+		return err
+	}
+
+	if params == nil {
+		return c.DefineName(name, body)
+	}
+	return c.DefineFunction(name, params, body)
+}
+
+// DefineFunction adds a function to the Context. Its body can use other
+// functions and names from the Context, but not in a way that ends up
+// referring back to itself.
+func (c *Context) DefineFunction(name string, params []string, body *Expression) error {
+	if err := c.checkName(name); err != nil {
+		return err
+	}
+	if len(params) == 0 {
+		return errors.New("Function needs at least one parameter: " + name)
+	}
+	seen := make(map[string]bool, len(params))
+	for _, p := range params {
+		if !isName(p) {
+			return errors.New("Invalid parameter name: " + p)
+		}
+		if seen[p] {
+			return errors.New("Repeated parameter: " + p)
+		}
+		seen[p] = true
+	}
+
+	return c.define(name, &Function{name, params, body}, nil)
+}
+
+// DefineName adds a named expression to the Context.
+func (c *Context) DefineName(name string, value *Expression) error {
+	if err := c.checkName(name); err != nil {
+		return err
+	}
+	return c.define(name, nil, value)
+}
+
+// define replaces whatever name is defined as with fn or value, as long as
+// that doesn't make any definitions refer to themselves.
+func (c *Context) define(name string, fn *Function, value *Expression) error {
+	oldFn, hadFn := c.funcs[name]
+	oldValue, hadValue := c.names[name]
+	delete(c.funcs, name)
+	delete(c.names, name)
+
+	check := value
+	if fn != nil {
+		c.funcs[name] = fn
+		check = substitute(fn.Body, placeholders(fn.Params, 0))
+	} else {
+		c.names[name] = value
+	}
+
+	if _, err := c.inline(check, []string{name}); err != nil {
+		delete(c.funcs, name)
+		delete(c.names, name)
+		if hadFn {
+			c.funcs[name] = oldFn
+		}
+		if hadValue {
+			c.names[name] = oldValue
+		}
+		return err
+	}
+	return nil
+}
+
+func (c *Context) checkName(name string) error {
+	if !isName(name) {
+		return errors.New("Invalid name: " + name)
+	}
+	if _, ok := words[strings.ToLower(name)]; ok {
+		return errors.New("Can't redefine " + name)
+	}
+	return nil
+}
+
+func isName(name string) bool {
+	if name == "" || !isLetter(name[0]) {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if !isIdentChar(name[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// scope gives the words the Context adds to the tokenizer, along with
+// params, which are variables while a function's body is parsed.
+func (c *Context) scope(params ...string) *scope {
+	sc := &scope{
+		words:   make(map[string]uint8, len(c.funcs)+len(c.names)+len(params)),
+		arities: make(map[string]int, len(c.funcs)),
+	}
+	for name, fn := range c.funcs {
+		sc.add(name, FUNC_PREFIX)
+		sc.arities[name] = len(fn.Params)
+	}
+	for name := range c.names {
+		sc.add(name, VARIABLE)
+	}
+	for _, p := range params {
+		sc.add(p, VARIABLE)
+		delete(sc.arities, p)
+	}
+	return sc
+}
+
+// function and name look up definitions, and work on a nil Context, which
+// has nothing defined.
+func (c *Context) function(name string) (*Function, bool) {
+	if c == nil {
+		return nil, false
+	}
+	fn, ok := c.funcs[name]
+	return fn, ok
+}
+
+func (c *Context) name(name string) (*Expression, bool) {
+	if c == nil {
+		return nil, false
+	}
+	value, ok := c.names[name]
+	return value, ok
+}
+
+// Inline replaces every use of the Context's functions and names in exp
+// with their definitions, giving an expression the evaluators can work on.
+func (c *Context) Inline(exp *Expression) (*Expression, error) {
+	return c.inline(exp, nil)
+}
+
+// inline does the work for Inline. expanding lists the definitions being
+// inlined further up, which mustn't turn up again.
+func (c *Context) inline(exp *Expression, expanding []string) (*Expression, error) {
+	if n := len(expanding); n > 0 {
+		for _, name := range expanding[:n-1] {
+			if name == expanding[n-1] {
+				return nil, errors.New("Definition refers to itself: " + name)
+			}
+		}
+	}
+
+	switch exp.Type {
+	case VARIABLE:
+		if value, ok := c.names[exp.Op]; ok {
+			return c.inline(value, append(expanding, exp.Op))
+		}
+		return exp, nil
+
+	case FUNC_PREFIX:
+		fn, ok := c.funcs[exp.Op]
+		if !ok {
+			break
+		}
+
+		args := exp.Args()
+		if len(args) != len(fn.Params) {
+			return nil, errors.New("Wrong number of arguments for " + exp.Op)
+		}
+		bind := make(map[string]*Expression, len(args))
+		for i, arg := range args {
+			arg, err := c.inline(arg, expanding)
+			if err != nil {
+				return nil, err
+			}
+			bind[fn.Params[i]] = arg
+		}
+
+		// The arguments are substituted in first, so the parameters hide
+		// any names they share with the Context. What's left of the body
+		// then gets inlined, which leaves the arguments alone as there's
+		// nothing left in them to inline.
+		return c.inline(substitute(fn.Body, bind), append(expanding, exp.Op))
+	}
+
+	if exp.Left == nil && exp.Right == nil {
+		return exp, nil
+	}
+
+	e := &Expression{exp.Op, exp.Type, nil, nil}
+	var err error
+	if exp.Left != nil {
+		if e.Left, err = c.inline(exp.Left, expanding); err != nil {
+			return nil, err
+		}
+	}
+	if exp.Right != nil {
+		if e.Right, err = c.inline(exp.Right, expanding); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+// substitute copies exp, replacing variables with the expressions bound to
+// them.
+func substitute(exp *Expression, bind map[string]*Expression) *Expression {
+	if exp.Type == VARIABLE {
+		if value, ok := bind[exp.Op]; ok {
+			return value
+		}
+		return exp
+	}
+	if exp.Left == nil && exp.Right == nil {
+		return exp
+	}
+
+	e := &Expression{exp.Op, exp.Type, nil, nil}
+	if exp.Left != nil {
+		e.Left = substitute(exp.Left, bind)
+	}
+	if exp.Right != nil {
+		e.Right = substitute(exp.Right, bind)
+	}
+	return e
+}
+
+// Differentiate is Expression.Differentiate, but goes through the
+// Context's functions with the chain rule, and through names to their
+// definitions.
+func (c *Context) Differentiate(exp *Expression, respect string) (*Expression, error) {
+	return exp.differentiate(respect, c)
+}
+
+// Eval inlines the Context's definitions in exp and evaluates it.
+func (c *Context) Eval(exp *Expression, env map[string]float64) (float64, error) {
+	exp, err := c.Inline(exp)
+	if err != nil {
+		return 0, err
+	}
+	return exp.Eval(env)
+}
+
+// chainRule differentiates a call to fn: d/dx f(g1(x), ..., gn(x)) is the
+// sum of the partial derivatives of f with respect to each parameter, at
+// g1(x), ..., gn(x), times gi'(x), plus the derivative of the body itself
+// for any other variables it uses. The partials are worked out with the
+// parameters renamed to things that can't be typed, so that they can't be
+// confused with any of the Context's names.
+func (c *Context) chainRule(fn *Function, call *Expression, respect string) (*Expression, error) {
+	args := call.Args()
+	if len(args) != len(fn.Params) {
+		return nil, errors.New("Wrong number of arguments for " + call.Op)
+	}
+
+	// respect can be a placeholder itself, when this call is in the body of
+	// another function, so these ones are numbered after it.
+	from := 0
+	if strings.HasPrefix(respect, "#") {
+		n, _ := strconv.Atoi(respect[1:])
+		from = n + 1
+	}
+
+	bind := make(map[string]*Expression, len(args))
+	for i, arg := range args {
+		bind[placeholder(from+i)] = arg
+	}
+	body := substitute(fn.Body, placeholders(fn.Params, from))
+
+	// The body can use variables other than its parameters, which are
+	// whatever they are where it's called, so f(x) = a*x depends on a
+	// directly as well as through x.
+	sum, err := body.differentiate(respect, c)
+	if err != nil {
+		return nil, err
+	}
+	sum = substitute(sum, bind)
+	if sum.Type == NUMBER && sum.Op == "0" {
+		sum = nil
+	}
+
+	for i, arg := range args {
+		gdash, err := arg.differentiate(respect, c)
+		if err != nil {
+			return nil, err
+		}
+		if gdash.Type == NUMBER && gdash.Op == "0" {
+			continue
+		}
+
+		fdash, err := body.differentiate(placeholder(from+i), c)
+		if err != nil {
+			return nil, err
+		}
+
+		term := mul(substitute(fdash, bind), gdash)
+		if sum == nil {
+			sum = term
+		} else {
+			sum = add(sum, term)
+		}
+	}
+
+	if sum == nil {
+		return no("0"), nil
+	}
+	return sum, nil
+}
+
+// placeholders maps function parameters to variables that can't be typed,
+// so they can't clash with any names, numbered from from.
+func placeholders(params []string, from int) map[string]*Expression {
+	bind := make(map[string]*Expression, len(params))
+	for i, p := range params {
+		bind[p] = &Expression{placeholder(from + i), VARIABLE, nil, nil}
+	}
+	return bind
+}
+
+func placeholder(i int) string {
+	return "#" + strconv.Itoa(i)
+}
+
+// parseSignature parses the left hand side of a definition: a name, and
+// for a function its parameters in brackets. params is nil for a name.
+func parseSignature(s string) (name string, params []string, err *ParseError) {
+	pos := 0
+	skipSpace := func() {
+		for pos < len(s) && (s[pos] == ' ' || s[pos] == '\t') {
+			pos++
+		}
+	}
+	ident := func() (string, *ParseError) {
+		skipSpace()
+		start := pos
+		for pos < len(s) && isIdentChar(s[pos]) {
+			pos++
+		}
+		if start == pos || !isLetter(s[start]) {
+			return "", &ParseError{Offset: start, Msg: "Expected a name"}
+		}
+		return s[start:pos], nil
+	}
+
+	if name, err = ident(); err != nil {
+		return "", nil, err
+	}
+
+	skipSpace()
+	if pos < len(s) && s[pos] == '(' {
+		pos++
+		params = []string{}
+		for {
+			p, err := ident()
+			if err != nil {
+				return "", nil, err
+			}
+			params = append(params, p)
+
+			skipSpace()
+			if pos < len(s) && s[pos] == ',' {
+				pos++
+				continue
+			}
+			if pos < len(s) && s[pos] == ')' {
+				pos++
+				break
+			}
+			return "", nil, &ParseError{Offset: pos, Msg: "Expected ',' or ')'"}
+		}
+	}
+
+	skipSpace()
+	if pos < len(s) {
+		return "", nil, &ParseError{Offset: pos, Token: s[pos : pos+1], Msg: "Unexpected token"}
+	}
+	return name, params, nil
+}
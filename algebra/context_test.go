@@ -0,0 +1,68 @@
+package algebra
+
+import "testing"
+
+func TestContextDifferentiateFreeVariables(t *testing.T) {
+	c := NewContext(ParseOptions{})
+	for _, def := range []string{"f(x) = a*x", "g(x, y) = x*y + f(y)"} {
+		if err := c.Define(def); err != nil {
+			t.Fatal(def, err)
+		}
+	}
+
+	env := map[string]float64{"a": 3, "t": 2}
+	for _, test := range []struct {
+		in, respect string
+		want        float64
+	}{
+		{"f(2)", "a", 2},
+		{"f(a^2)", "a", 27},
+		{"g(t, t)", "t", 2*2 + 3},
+		{"g(t, t)", "a", 2},
+	} {
+		e, err := c.Parse(test.in)
+		if err != nil {
+			t.Fatal(test.in, err)
+		}
+		d, err := c.Differentiate(e, test.respect)
+		if err != nil {
+			t.Errorf("d/d%s %s: %v", test.respect, test.in, err)
+			continue
+		}
+		if got, err := c.Eval(d, env); err != nil || got != test.want {
+			t.Errorf("d/d%s %s = %v, %v, want %v", test.respect, test.in, got, err, test.want)
+		}
+	}
+}
+
+func TestContextRedefineArity(t *testing.T) {
+	c := NewContext(ParseOptions{})
+	if err := c.Define("f(x) = x^2"); err != nil {
+		t.Fatal(err)
+	}
+	e, _ := c.Parse("f(3)")
+	if err := c.Define("f(x, y) = x + y"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.Eval(e, nil); err == nil {
+		t.Error("Eval: no error")
+	}
+	if _, err := c.Differentiate(e, "x"); err == nil {
+		t.Error("Differentiate: no error")
+	}
+}
+
+func TestContextRecursion(t *testing.T) {
+	c := NewContext(ParseOptions{})
+	for _, def := range []string{"h(x) = h(x)", "h(x) = 1 + h(x - 1)", "k = 2k", "k = sin(k)"} {
+		if err := c.Define(def); err == nil {
+			t.Errorf("%s: no error", def)
+		}
+	}
+
+	// A parameter hides the function's own name
+	if err := c.Define("q(q) = q^2"); err != nil {
+		t.Error(err)
+	}
+}
@@ -15,6 +15,12 @@ Standard operations:
 */
 
 func (exp *Expression) Differentiate(respect string) (*Expression, error) {
+	return exp.differentiate(respect, nil)
+}
+
+// differentiate does the work for Differentiate. If ctx isn't nil, its
+// functions and named expressions are differentiated through as well.
+func (exp *Expression) differentiate(respect string, ctx *Context) (*Expression, error) {
 	opType := exp.Type
 	op := exp.Op
 
@@ -25,6 +31,8 @@ func (exp *Expression) Differentiate(respect string) (*Expression, error) {
 	case VARIABLE:
 		if op == respect {
 			return &Expression{"1", NUMBER, nil, nil}, nil
+		} else if value, ok := ctx.name(op); ok {
+			return value.differentiate(respect, ctx)
 		} else {
 			return &Expression{"0", NUMBER, nil, nil}, nil
 		}
@@ -32,8 +40,8 @@ func (exp *Expression) Differentiate(respect string) (*Expression, error) {
 	case OP_LOW, OP_MED, OP_HIGH:
 		f := exp.Left
 		g := exp.Right
-		fdash, err := exp.Left.Differentiate(respect)
-		gdash, err2 := exp.Right.Differentiate(respect)
+		fdash, err := exp.Left.differentiate(respect, ctx)
+		gdash, err2 := exp.Right.differentiate(respect, ctx)
 
 		if err != nil || err2 != nil {
 			if err == nil {
@@ -68,8 +76,15 @@ func (exp *Expression) Differentiate(respect string) (*Expression, error) {
 		}
 
 	case FUNC_PREFIX:
+		if fn, ok := ctx.function(op); ok {
+			return ctx.chainRule(fn, exp, respect)
+		}
+		if exp.Left.Type == COMMA {
+			return exp.differentiateMulti(respect, ctx)
+		}
+
 		f := exp.Left
-		fdash, err := exp.Left.Differentiate(respect)
+		fdash, err := exp.Left.differentiate(respect, ctx)
 
 		if err != nil {
 			return nil, err
@@ -132,6 +147,16 @@ func (exp *Expression) Differentiate(respect string) (*Expression, error) {
 									apply("ln", no("10")),
 									f)), nil
 
+		case "abs":
+			// abs(f(x))		->	f'(x)*sign(f(x))
+			return	mul(
+								fdash,
+								apply("sign", f)), nil
+
+		case "sign":
+			// sign(f(x))		->	0, apart from where f(x) = 0
+			return no("0"), nil
+
 		case "sqrt":
 			// sqrt(f(x))		->	f'(x)/(2*sqrt(f(x)))
 			return	div(
@@ -326,6 +351,14 @@ func (exp *Expression) Differentiate(respect string) (*Expression, error) {
 											"2"))), nil
 		}
 
+	case NEGATE:
+		// -f(x)				->	-f'(x)
+		fdash, err := exp.Left.differentiate(respect, ctx)
+		if err != nil {
+			return nil, err
+		}
+		return neg(fdash), nil
+
 	default:
 		return nil, errors.New("Unknown operator: " + exp.Op)
 	}
@@ -333,6 +366,106 @@ func (exp *Expression) Differentiate(respect string) (*Expression, error) {
 	return exp, nil
 }
 
+// differentiateMulti differentiates the functions with more than one
+// argument.
+func (exp *Expression) differentiateMulti(respect string, ctx *Context) (*Expression, error) {
+	args := exp.Args()
+
+	switch canonicalFunc(exp.Op) {
+	case "log":
+		// log(b, f(x))	->	d/dx ln(f(x))/ln(b)
+		return	div(
+							apply("ln", args[1]),
+							apply("ln", args[0])).differentiate(respect, ctx)
+
+	case "atan2":
+		// atan2(f(x), g(x))	->	(g(x)f'(x) - f(x)g'(x))/(f(x)^2 + g(x)^2)
+		f, g := args[0], args[1]
+		fdash, err := f.differentiate(respect, ctx)
+		if err != nil {
+			return nil, err
+		}
+		gdash, err := g.differentiate(respect, ctx)
+		if err != nil {
+			return nil, err
+		}
+		return	div(
+							sub(mul(g, fdash), mul(f, gdash)),
+							add(powen(f, "2"), powen(g, "2"))), nil
+
+	case "root":
+		// root(n, f(x))	->	root(n, f(x))*f'(x)/(n*f(x)), which works for
+		// odd roots of negative numbers too. If n isn't constant there's
+		// another term: -root(n, f(x))*ln(f(x))*n'/n^2
+		n, f := args[0], args[1]
+		fdash, err := f.differentiate(respect, ctx)
+		if err != nil {
+			return nil, err
+		}
+		d := div(mul(exp, fdash), mul(n, f))
+		if n.IsConstant() {
+			return d, nil
+		}
+
+		ndash, err := n.differentiate(respect, ctx)
+		if err != nil {
+			return nil, err
+		}
+		return	sub(
+							d,
+							div(
+								mul(exp, mul(apply("ln", f), ndash)),
+								powen(n, "2"))), nil
+
+	case "hypot":
+		// hypot(f(x), g(x), ...)	->	(f(x)f'(x) + g(x)g'(x) + ...)/hypot(...)
+		var sum *Expression
+		for _, f := range args {
+			fdash, err := f.differentiate(respect, ctx)
+			if err != nil {
+				return nil, err
+			}
+			if sum == nil {
+				sum = mul(f, fdash)
+			} else {
+				sum = add(sum, mul(f, fdash))
+			}
+		}
+		return div(sum, exp), nil
+
+	case "min", "max":
+		// min(f(x), g(x))	->	(f'(x) + g'(x) - (f(x) - g(x))(f'(x) - g'(x))/|f(x) - g(x)|)/2
+		// which is the derivative of whichever is smaller, and can't be
+		// evaluated where they're equal, as there isn't one there. max
+		// has a + in the middle, and more arguments are done two at a time:
+		// min(f, g, h) is min(min(f, g), h).
+		f := args[0]
+		fdash, err := f.differentiate(respect, ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, g := range args[1:] {
+			gdash, err := g.differentiate(respect, ctx)
+			if err != nil {
+				return nil, err
+			}
+
+			branch := div(
+								mul(sub(f, g), sub(fdash, gdash)),
+								apply("abs", sub(f, g)))
+			if canonicalFunc(exp.Op) == "min" {
+				fdash = div(sub(add(fdash, gdash), branch), no("2"))
+			} else {
+				fdash = div(add(add(fdash, gdash), branch), no("2"))
+			}
+			f = &Expression{exp.Op, FUNC_PREFIX, argList([]*Expression{f, g}), nil}
+		}
+		return fdash, nil
+	}
+
+	return nil, errors.New("Can't differentiate: " + exp.Op)
+}
+
 func add(e1, e2 *Expression) *Expression {
 	return &Expression{"+", OP_LOW, e1, e2}
 }
@@ -390,7 +523,7 @@ func apply(fn string, e *Expression) *Expression {
 }
 
 func neg(e *Expression) *Expression {
-	return muln("-1", e)
+	return &Expression{"-", NEGATE, e, nil}
 }
 
 func no(num string) *Expression {
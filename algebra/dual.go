@@ -0,0 +1,278 @@
+package algebra
+
+import (
+	"errors"
+	"math"
+	"strconv"
+)
+
+// Dual is a dual number Val + Deriv*ε, where ε^2 = 0. Doing arithmetic on
+// them carries a derivative along with every value, so evaluating an
+// expression with duals gives its derivative as well as its value without
+// having to build the derivative's tree.
+type Dual struct {
+	Val, Deriv float64
+}
+
+// realDerivs gives the derivative of each of the (canonically named) prefix
+// functions, matching the rules in Differentiate.
+var realDerivs = map[string]func(float64) float64{
+	"sin": math.Cos,
+	"cos": func(x float64) float64 { return -math.Sin(x) },
+	"tan": func(x float64) float64 { return 1 / (math.Cos(x) * math.Cos(x)) },
+	"sec": func(x float64) float64 { return math.Tan(x) / math.Cos(x) },
+	"csc": func(x float64) float64 { return -1 / (math.Tan(x) * math.Sin(x)) },
+	"cot": func(x float64) float64 { return -1 / (math.Sin(x) * math.Sin(x)) },
+
+	"asin": func(x float64) float64 { return 1 / math.Sqrt(1-x*x) },
+	"acos": func(x float64) float64 { return -1 / math.Sqrt(1-x*x) },
+	"atan": func(x float64) float64 { return 1 / (1 + x*x) },
+	"asec": func(x float64) float64 { return 1 / (x * x * math.Sqrt(1-1/(x*x))) },
+	"acsc": func(x float64) float64 { return -1 / (x * x * math.Sqrt(1-1/(x*x))) },
+	"acot": func(x float64) float64 { return -1 / (1 + x*x) },
+
+	"sinh": math.Cosh,
+	"cosh": math.Sinh,
+	"tanh": func(x float64) float64 { return 1 / (math.Cosh(x) * math.Cosh(x)) },
+	"sech": func(x float64) float64 { return -math.Tanh(x) / math.Cosh(x) },
+	"csch": func(x float64) float64 { return -1 / (math.Tanh(x) * math.Sinh(x)) },
+	"coth": func(x float64) float64 { return -1 / (math.Sinh(x) * math.Sinh(x)) },
+
+	"asinh": func(x float64) float64 { return 1 / math.Sqrt(1+x*x) },
+	"acosh": func(x float64) float64 { return 1 / (math.Sqrt(x-1) * math.Sqrt(x+1)) },
+	"atanh": func(x float64) float64 { return 1 / (1 - x*x) },
+	"asech": func(x float64) float64 { return -1 / (x * math.Sqrt(1-x*x)) },
+	"acsch": func(x float64) float64 { return -1 / (x * x * math.Sqrt(1+1/(x*x))) },
+	"acoth": func(x float64) float64 { return 1 / (1 - x*x) },
+
+	"ln":   func(x float64) float64 { return 1 / x },
+	"log":  func(x float64) float64 { return 1 / (x * math.Ln10) },
+	"sqrt": func(x float64) float64 { return 1 / (2 * math.Sqrt(x)) },
+	"abs":  sign,
+	"sign": func(x float64) float64 { return 0 },
+}
+
+// realMultiDerivs gives the partial derivatives of the functions with more
+// than one argument. min and max don't have them where their arguments are
+// equal, so they're worked out separately with extremes.
+var realMultiDerivs = map[string]func([]float64) []float64{
+	"log": func(x []float64) []float64 {
+		lb := math.Log(x[0])
+		return []float64{-math.Log(x[1]) / (x[0] * lb * lb), 1 / (x[1] * lb)}
+	},
+	"atan2": func(x []float64) []float64 {
+		r2 := x[0]*x[0] + x[1]*x[1]
+		return []float64{x[1] / r2, -x[0] / r2}
+	},
+	"root": func(x []float64) []float64 {
+		r := realRoot(x[0], x[1])
+		return []float64{-r * math.Log(x[1]) / (x[0] * x[0]), r / (x[0] * x[1])}
+	},
+	"hypot": func(x []float64) []float64 {
+		h := realMultiFuncs["hypot"](x)
+		d := make([]float64, len(x))
+		for i := range x {
+			d[i] = x[i] / h
+		}
+		return d
+	},
+}
+
+// EvalDerivative works out the value of the expression and its derivative
+// with respect to the variable respect, in a single pass over the tree.
+func (exp *Expression) EvalDerivative(env map[string]float64, respect string) (f, fdash float64, err error) {
+	duals := make(map[string]Dual, len(env))
+	for name, x := range env {
+		duals[name] = Dual{x, 0}
+	}
+	if x, ok := duals[respect]; ok {
+		duals[respect] = Dual{x.Val, 1}
+	}
+
+	d, err := exp.EvalDual(duals)
+	return d.Val, d.Deriv, err
+}
+
+// EvalDual evaluates the expression using dual numbers. The Deriv of each
+// variable in env is how fast it changes in the direction the derivative is
+// wanted in, so setting it to 1 for x and 0 for everything else gives the
+// partial derivative with respect to x.
+func (exp *Expression) EvalDual(env map[string]Dual) (Dual, error) {
+	op := exp.Op
+
+	switch exp.Type {
+	case NUMBER:
+		x, err := strconv.ParseFloat(op, 64)
+		return Dual{x, 0}, err
+
+	case CONSTANT:
+		if x, ok := realConstants[op]; ok {
+			return Dual{x, 0}, nil
+		}
+		return Dual{}, errors.New("Constant has no real value: " + op)
+
+	case VARIABLE:
+		if v, ok := env[op]; ok {
+			return v, nil
+		}
+		return Dual{}, &UnboundVariableError{op}
+
+	case OP_LOW, OP_MED, OP_HIGH:
+		a, err := exp.Left.EvalDual(env)
+		if err != nil {
+			return Dual{}, err
+		}
+		b, err := exp.Right.EvalDual(env)
+		if err != nil {
+			return Dual{}, err
+		}
+
+		switch op {
+		case "+", "-", "*", "/", "^":
+		default:
+			return Dual{}, errors.New("Unknown operator: " + op)
+		}
+		if !realOpDefined(op, a.Val, b.Val) {
+			return Dual{}, realDomainError(op, a.Val, b.Val)
+		}
+		return dualOp(op, a, b), nil
+
+	case FUNC_PREFIX:
+		if exp.Left.Type == COMMA {
+			return exp.evalDualMulti(env)
+		}
+
+		x, err := exp.Left.EvalDual(env)
+		if err != nil {
+			return Dual{}, err
+		}
+
+		name := canonicalFunc(op)
+		fn, ok := realFuncs[name]
+		if !ok {
+			return Dual{}, errors.New("Unknown function: " + op)
+		}
+		if inDomain, ok := realDomains[name]; ok && !inDomain(x.Val) {
+			return Dual{}, realDomainError(op, x.Val)
+		}
+		return dualChain(fn(x.Val), realDerivs[name](x.Val), x.Deriv), nil
+
+	case NEGATE:
+		x, err := exp.Left.EvalDual(env)
+		return Dual{-x.Val, -x.Deriv}, err
+
+	case FUNC_POSTFIX:
+		x, err := exp.Left.EvalDual(env)
+		if err != nil {
+			return Dual{}, err
+		}
+
+		if op != "!" {
+			return Dual{}, errors.New("Unknown function: " + op)
+		}
+		if !factorialDefined(x.Val) {
+			return Dual{}, realDomainError(op, x.Val)
+		}
+		// Differentiate can't do factorials either
+		if x.Deriv != 0 {
+			return Dual{}, errors.New("Can't differentiate: " + op)
+		}
+		return Dual{factorial(x.Val), 0}, nil
+
+	case EQUALS:
+		return Dual{}, errors.New("Can't evaluate an equation")
+	}
+
+	return Dual{}, errors.New("Unknown operator: " + op)
+}
+
+// evalDualMulti evaluates a function with more than one argument, adding
+// up how much each argument's derivative contributes to the result's.
+func (exp *Expression) evalDualMulti(env map[string]Dual) (Dual, error) {
+	args := exp.Args()
+	x := make([]Dual, len(args))
+	vals := make([]float64, len(args))
+	for i, arg := range args {
+		var err error
+		if x[i], err = arg.EvalDual(env); err != nil {
+			return Dual{}, err
+		}
+		vals[i] = x[i].Val
+	}
+
+	name := canonicalFunc(exp.Op)
+	fn, ok := realMultiFuncs[name]
+	if !ok {
+		return Dual{}, errors.New("Unknown function: " + exp.Op)
+	}
+	if inDomain, ok := realMultiDomains[name]; ok && !inDomain(vals) {
+		return Dual{}, realDomainError(exp.Op, vals...)
+	}
+
+	d := Dual{fn(vals), 0}
+
+	// min and max change like whichever argument they pick. Where that's
+	// more than one, they have to be changing at the same rate.
+	if name == "min" || name == "max" {
+		picked := extremes(name, vals)
+		if len(picked) == 0 {
+			return Dual{d.Val, math.NaN()}, nil
+		}
+		for _, i := range picked[1:] {
+			if x[i].Deriv != x[picked[0]].Deriv {
+				return Dual{}, errors.New("Can't differentiate " + exp.Op + " where its arguments are equal")
+			}
+		}
+		return Dual{d.Val, x[picked[0]].Deriv}, nil
+	}
+
+	var partials []float64
+	for i := range x {
+		if x[i].Deriv == 0 {
+			continue
+		}
+		if partials == nil {
+			derivs, ok := realMultiDerivs[name]
+			if !ok {
+				return Dual{}, errors.New("Can't differentiate: " + exp.Op)
+			}
+			partials = derivs(vals)
+		}
+		d.Deriv += partials[i] * x[i].Deriv
+	}
+	return d, nil
+}
+
+// dualChain applies the chain rule: the derivative of f(g) is f'(g)*g'. If
+// g' is zero the result is too, even where f' blows up.
+func dualChain(f, fdash, gdash float64) Dual {
+	if gdash == 0 {
+		return Dual{f, 0}
+	}
+	return Dual{f, fdash * gdash}
+}
+
+func dualOp(op string, a, b Dual) Dual {
+	switch op {
+	case "+":
+		return Dual{a.Val + b.Val, a.Deriv + b.Deriv}
+	case "-":
+		return Dual{a.Val - b.Val, a.Deriv - b.Deriv}
+	case "*":
+		return Dual{a.Val * b.Val, a.Deriv*b.Val + a.Val*b.Deriv}
+	case "/":
+		return Dual{a.Val / b.Val, (a.Deriv*b.Val - a.Val*b.Deriv) / (b.Val * b.Val)}
+	}
+
+	// a^b -> a^(b-1) * (b*a' + a*ln(a)*b'). The ln(a) term is left out when
+	// b is constant, so negative a works with integer powers.
+	val := math.Pow(a.Val, b.Val)
+	deriv := 0.0
+	if a.Deriv != 0 {
+		deriv = b.Val * math.Pow(a.Val, b.Val-1) * a.Deriv
+	}
+	if b.Deriv != 0 {
+		deriv += val * math.Log(a.Val) * b.Deriv
+	}
+	return Dual{val, deriv}
+}
@@ -0,0 +1,93 @@
+package algebra
+
+import (
+	"math"
+	"testing"
+)
+
+// dualExpressions are checked against Differentiate. Each one is tried at
+// every point in dualPoints, skipping the ones where it isn't defined.
+var dualExpressions = []string{
+	"x^2 + 3x*y - y", "x*y/(x + y)", "x^y", "y^x", "x^3", "2^x", "e^(x*y)", "pi x",
+	"-x^2", "1/x", "(x + 1)^(1/2)", "sqrt(x^2 + y^2)",
+	"log(2, x)", "log(x, y)", "atan2(y, x)", "root(3, x)", "root(x, y)", "hypot(x, y, 2)",
+	"sin(cos(x*y))", "ln(x^2) * e^y", "abs(x - y)", "sign(x)",
+	"min(x, y)", "max(x^2, y, 1)", "min(x*y, 2y, x)",
+}
+
+var dualPoints = [][2]float64{{0.3, 0.1}, {0.7, 2}, {1.7, -0.4}, {-2.5, 1.5}}
+
+// Some of the arguments are outside some functions' domains everywhere, but
+// every function should get checked with one of them. Otherwise a typo
+// could make a case that's never tested.
+func TestEvalDualFunctions(t *testing.T) {
+	for name := range realDerivs {
+		checked := 0
+		for _, arg := range []string{"(x^2 + y)", "(0.4x)", "(1/(3x))", "(x + 1)"} {
+			checked += checkDual(t, name+arg)
+		}
+		if checked == 0 {
+			t.Errorf("%s can't be evaluated at any of %v", name, dualPoints)
+		}
+	}
+}
+
+func TestEvalDualExpressions(t *testing.T) {
+	for _, s := range dualExpressions {
+		if checkDual(t, s) == 0 {
+			t.Errorf("%s can't be evaluated at any of %v", s, dualPoints)
+		}
+	}
+}
+
+// checkDual checks that EvalDual gives the same value as Eval, and the
+// same derivative as evaluating Differentiate's, for the derivatives with
+// respect to x and y, and in a direction in between them. It returns how
+// many of dualPoints it could check.
+func checkDual(t *testing.T, s string) (checked int) {
+	t.Helper()
+
+	e, err := Parse(s)
+	if err != nil {
+		t.Fatalf("%s: %v", s, err)
+	}
+	dx, err := e.Differentiate("x")
+	if err != nil {
+		t.Fatalf("d/dx %s: %v", s, err)
+	}
+	dy, err := e.Differentiate("y")
+	if err != nil {
+		t.Fatalf("d/dy %s: %v", s, err)
+	}
+
+	for _, p := range dualPoints {
+		env := map[string]float64{"x": p[0], "y": p[1]}
+		want, err := e.Eval(env)
+		if err != nil {
+			continue
+		}
+		wantX, errX := dx.Eval(env)
+		wantY, errY := dy.Eval(env)
+		if errX != nil || errY != nil || math.IsNaN(wantX+wantY) {
+			continue
+		}
+		checked++
+
+		for _, dir := range [][2]float64{{1, 0}, {0, 1}, {0.5, -2}} {
+			got, err := e.EvalDual(map[string]Dual{"x": {p[0], dir[0]}, "y": {p[1], dir[1]}})
+			if err != nil {
+				t.Errorf("%s at %v: %v", s, p, err)
+				continue
+			}
+			wantDeriv := dir[0]*wantX + dir[1]*wantY
+			if !approxEqual(got.Val, want) || !approxEqual(got.Deriv, wantDeriv) {
+				t.Errorf("%s at %v in direction %v: got %v, want {%v %v}", s, p, dir, got, want, wantDeriv)
+			}
+		}
+	}
+	return checked
+}
+
+func approxEqual(a, b float64) bool {
+	return math.Abs(a-b) <= 1e-9*math.Max(1, math.Abs(b))
+}
@@ -0,0 +1,318 @@
+package algebra
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// UnboundVariableError is returned when an expression refers to a variable
+// that has no value in the environment it's being evaluated in.
+type UnboundVariableError struct {
+	Name string
+}
+
+func (e *UnboundVariableError) Error() string {
+	return "Unbound variable: " + e.Name
+}
+
+// DomainError is returned when an operator or function is applied to
+// arguments it isn't defined for, like ln(-1) or 1/0.
+// Args holds the arguments formatted as strings, so that the same error can
+// come from any of the evaluators whatever kind of number they work with.
+type DomainError struct {
+	Op   string
+	Args []string
+}
+
+func (e *DomainError) Error() string {
+	return fmt.Sprintf("%s is undefined for %s", e.Op, strings.Join(e.Args, ", "))
+}
+
+func realDomainError(op string, args ...float64) error {
+	strs := make([]string, len(args))
+	for i, arg := range args {
+		strs[i] = strconv.FormatFloat(arg, 'g', -1, 64)
+	}
+	return &DomainError{op, strs}
+}
+
+// The tokenizer accepts all sorts of spellings for the inverse trig
+// functions (asin, arsin, arcsin, acosec, arcosec, ...). canonicalFunc maps
+// them down to a single name so the evaluators only need to know about one.
+func canonicalFunc(name string) string {
+	for _, prefix := range []string{"arc", "ar", "a"} {
+		if strings.HasPrefix(name, prefix) && isTrigName(name[len(prefix):]) {
+			return "a" + canonicalFunc(name[len(prefix):])
+		}
+	}
+
+	if strings.HasPrefix(name, "cosec") {
+		return "csc" + name[len("cosec"):]
+	}
+
+	return name
+}
+
+func isTrigName(name string) bool {
+	name = strings.TrimSuffix(name, "h")
+	switch name {
+	case "sin", "cos", "tan", "sec", "csc", "cot", "cosec":
+		return true
+	}
+	return false
+}
+
+// sec(x) = 1/cos(x) and so on
+var reciprocalFuncs = map[string]string{
+	"sec":  "cos",
+	"csc":  "sin",
+	"cot":  "tan",
+	"sech": "cosh",
+	"csch": "sinh",
+	"coth": "tanh",
+}
+
+// asec(x) = acos(1/x) and so on
+var reciprocalInverses = map[string]string{
+	"asec":  "acos",
+	"acsc":  "asin",
+	"acot":  "atan",
+	"asech": "acosh",
+	"acsch": "asinh",
+	"acoth": "atanh",
+}
+
+var realFuncs = map[string]func(float64) float64{
+	"sin": math.Sin,
+	"cos": math.Cos,
+	"tan": math.Tan,
+	"sec": func(x float64) float64 { return 1 / math.Cos(x) },
+	"csc": func(x float64) float64 { return 1 / math.Sin(x) },
+	"cot": func(x float64) float64 { return 1 / math.Tan(x) },
+
+	"asin": math.Asin,
+	"acos": math.Acos,
+	"atan": math.Atan,
+	"asec": func(x float64) float64 { return math.Acos(1 / x) },
+	"acsc": func(x float64) float64 { return math.Asin(1 / x) },
+	"acot": func(x float64) float64 {
+		// Otherwise -0 would give -pi/2
+		if x == 0 {
+			return math.Pi / 2
+		}
+		return math.Atan(1 / x)
+	},
+
+	"sinh": math.Sinh,
+	"cosh": math.Cosh,
+	"tanh": math.Tanh,
+	"sech": func(x float64) float64 { return 1 / math.Cosh(x) },
+	"csch": func(x float64) float64 { return 1 / math.Sinh(x) },
+	"coth": func(x float64) float64 { return 1 / math.Tanh(x) },
+
+	"asinh": math.Asinh,
+	"acosh": math.Acosh,
+	"atanh": math.Atanh,
+	"asech": func(x float64) float64 { return math.Acosh(1 / x) },
+	"acsch": func(x float64) float64 { return math.Asinh(1 / x) },
+	"acoth": func(x float64) float64 { return math.Atanh(1 / x) },
+
+	"ln":   math.Log,
+	"log":  math.Log10,
+	"sqrt": math.Sqrt,
+	"abs":  math.Abs,
+	"sign": sign,
+}
+
+// realDomains reports whether a function is defined at a point. Functions
+// that are defined everywhere aren't listed.
+var realDomains = map[string]func(float64) bool{
+	"csc": func(x float64) bool { return math.Sin(x) != 0 },
+	"cot": func(x float64) bool { return math.Tan(x) != 0 },
+
+	"asin": func(x float64) bool { return x >= -1 && x <= 1 },
+	"acos": func(x float64) bool { return x >= -1 && x <= 1 },
+	"asec": func(x float64) bool { return x <= -1 || x >= 1 },
+	"acsc": func(x float64) bool { return x <= -1 || x >= 1 },
+
+	"csch": func(x float64) bool { return x != 0 },
+	"coth": func(x float64) bool { return x != 0 },
+
+	"acosh": func(x float64) bool { return x >= 1 },
+	"atanh": func(x float64) bool { return x > -1 && x < 1 },
+	"asech": func(x float64) bool { return x > 0 && x <= 1 },
+	"acsch": func(x float64) bool { return x != 0 },
+	"acoth": func(x float64) bool { return x < -1 || x > 1 },
+
+	"ln":   func(x float64) bool { return x > 0 },
+	"log":  func(x float64) bool { return x > 0 },
+	"sqrt": func(x float64) bool { return x >= 0 },
+}
+
+var realConstants = map[string]float64{
+	"e":  math.E,
+	"pi": math.Pi,
+}
+
+// Eval works out the numeric value of the expression, looking up the values
+// of any variables in env.
+func (exp *Expression) Eval(env map[string]float64) (float64, error) {
+	op := exp.Op
+
+	switch exp.Type {
+	case NUMBER:
+		return strconv.ParseFloat(op, 64)
+
+	case CONSTANT:
+		if c, ok := realConstants[op]; ok {
+			return c, nil
+		}
+		return 0, errors.New("Constant has no real value: " + op)
+
+	case VARIABLE:
+		if v, ok := env[op]; ok {
+			return v, nil
+		}
+		return 0, &UnboundVariableError{op}
+
+	case OP_LOW, OP_MED, OP_HIGH:
+		a, err := exp.Left.Eval(env)
+		if err != nil {
+			return 0, err
+		}
+		b, err := exp.Right.Eval(env)
+		if err != nil {
+			return 0, err
+		}
+
+		switch op {
+		case "+", "-", "*", "/", "^":
+		default:
+			return 0, errors.New("Unknown operator: " + op)
+		}
+		if !realOpDefined(op, a, b) {
+			return 0, realDomainError(op, a, b)
+		}
+		return realOp(op, a, b), nil
+
+	case FUNC_PREFIX:
+		if exp.Left.Type == COMMA {
+			return exp.evalMulti(env)
+		}
+
+		x, err := exp.Left.Eval(env)
+		if err != nil {
+			return 0, err
+		}
+
+		name := canonicalFunc(op)
+		fn, ok := realFuncs[name]
+		if !ok {
+			return 0, errors.New("Unknown function: " + op)
+		}
+		if inDomain, ok := realDomains[name]; ok && !inDomain(x) {
+			return 0, realDomainError(op, x)
+		}
+		return fn(x), nil
+
+	case NEGATE:
+		x, err := exp.Left.Eval(env)
+		return -x, err
+
+	case FUNC_POSTFIX:
+		x, err := exp.Left.Eval(env)
+		if err != nil {
+			return 0, err
+		}
+
+		if op != "!" {
+			return 0, errors.New("Unknown function: " + op)
+		}
+		if !factorialDefined(x) {
+			return 0, realDomainError(op, x)
+		}
+		return factorial(x), nil
+
+	case EQUALS:
+		return 0, errors.New("Can't evaluate an equation")
+	}
+
+	return 0, errors.New("Unknown operator: " + op)
+}
+
+// evalMulti evaluates a function with more than one argument
+func (exp *Expression) evalMulti(env map[string]float64) (float64, error) {
+	args := exp.Args()
+	x := make([]float64, len(args))
+	for i, arg := range args {
+		var err error
+		if x[i], err = arg.Eval(env); err != nil {
+			return 0, err
+		}
+	}
+
+	name := canonicalFunc(exp.Op)
+	fn, ok := realMultiFuncs[name]
+	if !ok {
+		return 0, errors.New("Unknown function: " + exp.Op)
+	}
+	if inDomain, ok := realMultiDomains[name]; ok && !inDomain(x) {
+		return 0, realDomainError(exp.Op, x...)
+	}
+	return fn(x), nil
+}
+
+func realOp(op string, a, b float64) float64 {
+	switch op {
+	case "+":
+		return a + b
+	case "-":
+		return a - b
+	case "*":
+		return a * b
+	case "/":
+		return a / b
+	case "^":
+		return math.Pow(a, b)
+	}
+	return math.NaN()
+}
+
+func realOpDefined(op string, a, b float64) bool {
+	switch op {
+	case "+", "-", "*":
+		return true
+	case "/":
+		return b != 0
+	case "^":
+		if a == 0 {
+			return b >= 0
+		}
+		return a > 0 || b == math.Trunc(b)
+	}
+	return false
+}
+
+// sign is -1, 0 or 1 depending on the sign of x
+func sign(x float64) float64 {
+	switch {
+	case x > 0:
+		return 1
+	case x < 0:
+		return -1
+	}
+	return x
+}
+
+// factorial extends n! to the reals with the gamma function, so it's
+// undefined only at the negative integers.
+func factorial(x float64) float64 {
+	return math.Gamma(x + 1)
+}
+
+func factorialDefined(x float64) bool {
+	return x >= 0 || x != math.Trunc(x)
+}
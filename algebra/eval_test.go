@@ -0,0 +1,54 @@
+package algebra
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestEvalDomainErrors(t *testing.T) {
+	for _, test := range []struct {
+		in   string
+		op   string
+		args []string
+	}{
+		{"ln(-1)", "ln", []string{"-1"}},
+		{"asin(2)", "asin", []string{"2"}},
+		{"0^-1", "^", []string{"0", "-1"}},
+		{"1/(x - x)", "/", []string{"1", "0"}},
+		{"log(1, 2)", "log", []string{"1", "2"}},
+	} {
+		e, err := Parse(test.in)
+		if err != nil {
+			t.Fatal(test.in, err)
+		}
+
+		var domain *DomainError
+		_, err = e.Eval(map[string]float64{"x": 3})
+		if !errors.As(err, &domain) {
+			t.Errorf("%s: got %v, want a domain error", test.in, err)
+		} else if domain.Op != test.op || !reflect.DeepEqual(domain.Args, test.args) {
+			t.Errorf("%s: got %s %v want %s %v", test.in, domain.Op, domain.Args, test.op, test.args)
+		}
+	}
+}
+
+func TestEvalUnboundVariable(t *testing.T) {
+	e, err := Parse("x + sin(y)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var unbound *UnboundVariableError
+	_, err = e.Eval(map[string]float64{"x": 1})
+	if !errors.As(err, &unbound) || unbound.Name != "y" {
+		t.Errorf("got %v, want y unbound", err)
+	}
+	if err != nil && err.Error() != "Unbound variable: y" {
+		t.Errorf("got message %q", err.Error())
+	}
+
+	if got, err := e.Eval(map[string]float64{"x": 1, "y": 0}); err != nil || got != 1 {
+		t.Errorf("got %v, %v want 1", got, err)
+	}
+}
@@ -0,0 +1,805 @@
+package algebra
+
+import (
+	"errors"
+	"math/big"
+)
+
+// bigGuardBits is how much extra precision EvalBig works at, so that the
+// rounding errors that build up over the tree don't reach the bits that
+// get returned.
+const bigGuardBits = 64
+
+// OverflowError is returned by EvalBig when a value gets too big for a
+// big.Float to hold, which is somewhere around 2^2147483647.
+type OverflowError struct {
+	Op string
+}
+
+func (e *OverflowError) Error() string {
+	return "Overflow in " + e.Op
+}
+
+// EvalBig works out the value of the expression to prec bits of precision,
+// looking up the values of any variables in env.
+func (exp *Expression) EvalBig(env map[string]*big.Float, prec uint) (*big.Float, error) {
+	x, err := exp.evalBig(env, prec+bigGuardBits)
+	if err != nil {
+		return nil, err
+	}
+	return newBig(prec).Set(x), nil
+}
+
+// evalBig checks every value on the way up the tree for infinities, as
+// big.Float panics rather than giving NaN for things like Inf - Inf.
+func (exp *Expression) evalBig(env map[string]*big.Float, prec uint) (*big.Float, error) {
+	x, err := exp.evalBigNode(env, prec)
+	if err == nil && x.IsInf() {
+		return nil, &OverflowError{exp.Op}
+	}
+	return x, err
+}
+
+func (exp *Expression) evalBigNode(env map[string]*big.Float, prec uint) (*big.Float, error) {
+	op := exp.Op
+
+	switch exp.Type {
+	case NUMBER:
+		x, _, err := big.ParseFloat(op, 10, prec, big.ToNearestEven)
+		return x, err
+
+	case CONSTANT:
+		switch op {
+		case "e":
+			return bigExp(newBig(prec).SetInt64(1), prec), nil
+		case "pi":
+			return bigPi(prec), nil
+		}
+		return nil, errors.New("Constant has no real value: " + op)
+
+	case VARIABLE:
+		if v, ok := env[op]; ok {
+			return v, nil
+		}
+		return nil, &UnboundVariableError{op}
+
+	case OP_LOW, OP_MED, OP_HIGH:
+		a, err := exp.Left.evalBig(env, prec)
+		if err != nil {
+			return nil, err
+		}
+		b, err := exp.Right.evalBig(env, prec)
+		if err != nil {
+			return nil, err
+		}
+
+		z := newBig(prec)
+		switch op {
+		case "+":
+			return z.Add(a, b), nil
+		case "-":
+			return z.Sub(a, b), nil
+		case "*":
+			return z.Mul(a, b), nil
+		case "/":
+			if b.Sign() == 0 {
+				return nil, bigDomainError(op, a, b)
+			}
+			return z.Quo(a, b), nil
+		case "^":
+			if r, ok := bigPow(a, b, prec); ok {
+				return r, nil
+			}
+			return nil, bigDomainError(op, a, b)
+		}
+		return nil, errors.New("Unknown operator: " + op)
+
+	case FUNC_PREFIX:
+		if exp.Left.Type == COMMA {
+			args := exp.Args()
+			x := make([]*big.Float, len(args))
+			for i, arg := range args {
+				var err error
+				if x[i], err = arg.evalBig(env, prec); err != nil {
+					return nil, err
+				}
+			}
+
+			name := canonicalFunc(op)
+			if _, ok := realMultiFuncs[name]; !ok {
+				return nil, errors.New("Unknown function: " + op)
+			}
+			if z, ok := bigMultiFunc(name, x, prec); ok {
+				return z, nil
+			}
+			return nil, bigDomainError(op, x...)
+		}
+
+		x, err := exp.Left.evalBig(env, prec)
+		if err != nil {
+			return nil, err
+		}
+
+		name := canonicalFunc(op)
+		if _, ok := realFuncs[name]; !ok {
+			return nil, errors.New("Unknown function: " + op)
+		}
+		if z, ok := bigFunc(name, x, prec); ok {
+			return z, nil
+		}
+		return nil, bigDomainError(op, x)
+
+	case NEGATE:
+		x, err := exp.Left.evalBig(env, prec)
+		if err != nil {
+			return nil, err
+		}
+		return newBig(prec).Neg(x), nil
+
+	case FUNC_POSTFIX:
+		x, err := exp.Left.evalBig(env, prec)
+		if err != nil {
+			return nil, err
+		}
+
+		if op != "!" {
+			return nil, errors.New("Unknown function: " + op)
+		}
+		if !x.IsInt() {
+			return nil, errors.New("Can't take the factorial of a non-integer to arbitrary precision")
+		}
+		n, acc := x.Int64()
+		if n < 0 {
+			return nil, bigDomainError(op, x)
+		}
+		if acc != big.Exact {
+			return nil, &OverflowError{op}
+		}
+		if z, ok := bigFactorial(n, prec); ok {
+			return z, nil
+		}
+		return nil, errors.New("Can't take the factorial of a number this big to that precision")
+
+	case EQUALS:
+		return nil, errors.New("Can't evaluate an equation")
+	}
+
+	return nil, errors.New("Unknown operator: " + op)
+}
+
+func bigDomainError(op string, args ...*big.Float) error {
+	strs := make([]string, len(args))
+	for i, arg := range args {
+		strs[i] = arg.String()
+	}
+	return &DomainError{op, strs}
+}
+
+func newBig(prec uint) *big.Float {
+	return new(big.Float).SetPrec(prec)
+}
+
+func bigOne(prec uint) *big.Float {
+	return newBig(prec).SetInt64(1)
+}
+
+// bigFunc applies one of the (canonically named) prefix functions to x. ok
+// is false if x is outside of the function's domain.
+func bigFunc(name string, x *big.Float, prec uint) (z *big.Float, ok bool) {
+	one := bigOne(prec)
+
+	switch name {
+	case "sin":
+		return bigSin(x, prec), true
+	case "cos":
+		return bigCos(x, prec), true
+	case "tan", "cot", "sec", "csc":
+		s, c := bigSin(x, prec+16), bigCos(x, prec+16)
+		switch name {
+		case "tan":
+			return bigQuo(s, c, prec)
+		case "cot":
+			return bigQuo(c, s, prec)
+		case "sec":
+			return bigQuo(one, c, prec)
+		}
+		return bigQuo(one, s, prec)
+
+	case "asin":
+		return bigAsin(x, prec)
+	case "acos":
+		return bigAcos(x, prec)
+	case "atan":
+		return bigAtan(x, prec), true
+	case "asec", "acsc", "acot":
+		if x.Sign() == 0 {
+			if name == "acot" {
+				r := bigPi(prec)
+				return r.SetMantExp(r, -1), true
+			}
+			return nil, false
+		}
+		return bigFunc(reciprocalInverses[name], newBig(prec+16).Quo(one, x), prec)
+
+	case "sinh", "cosh", "tanh", "sech", "csch", "coth":
+		// e^x - e^-x cancels out badly for small x, so work with more bits
+		wp := prec + 16
+		if e := x.MantExp(nil); e < 0 {
+			wp += uint(-e)
+		}
+		ex := bigExp(x, wp)
+		emx := newBig(wp).Quo(bigOne(wp), ex)
+		s := newBig(wp).Sub(ex, emx)
+		c := newBig(wp).Add(ex, emx)
+		switch name {
+		case "sinh":
+			return newBig(prec).SetMantExp(s, -1), true
+		case "cosh":
+			return newBig(prec).SetMantExp(c, -1), true
+		case "tanh", "coth":
+			// By the time e^x overflows these are 1 to any sensible
+			// precision, and Inf/Inf would panic
+			if s.IsInf() {
+				return newBig(prec).SetInt64(int64(s.Sign())), true
+			}
+			if name == "coth" {
+				return bigQuo(c, s, prec)
+			}
+			return bigQuo(s, c, prec)
+		case "sech":
+			return bigQuo(newBig(wp).SetInt64(2), c, prec)
+		}
+		return bigQuo(newBig(wp).SetInt64(2), s, prec)
+
+	case "asinh":
+		// asinh(x) = ln(x + sqrt(x^2 + 1)), worked out for |x| to avoid
+		// cancellation
+		wp := bigSmallArgPrec(x, prec)
+		a := newBig(wp).Abs(x)
+		r := newBig(wp).Mul(a, a)
+		r.Add(r, bigOne(wp))
+		r.Sqrt(r)
+		r.Add(r, a)
+		r = bigLog(r, prec)
+		if x.Sign() < 0 {
+			r.Neg(r)
+		}
+		return r, true
+
+	case "acosh":
+		// acosh(x) = ln(x + sqrt(x^2 - 1))
+		if x.Cmp(one) < 0 {
+			return nil, false
+		}
+		wp := prec + 16
+		r := newBig(wp).Sub(x, one)
+		r.Mul(r, newBig(wp).Add(x, one))
+		r.Sqrt(r)
+		r.Add(r, x)
+		return bigLog(r, prec), true
+
+	case "atanh":
+		// atanh(x) = ln((1 + x)/(1 - x))/2
+		if newBig(prec).Abs(x).Cmp(one) >= 0 {
+			return nil, false
+		}
+		wp := bigSmallArgPrec(x, prec)
+		r := newBig(wp).Add(one, x)
+		r.Quo(r, newBig(wp).Sub(one, x))
+		r = bigLog(r, prec)
+		return r.SetMantExp(r, -1), true
+
+	case "asech", "acsch", "acoth":
+		if x.Sign() == 0 {
+			return nil, false
+		}
+		return bigFunc(reciprocalInverses[name], newBig(prec+16).Quo(one, x), prec)
+
+	case "ln", "log":
+		if x.Sign() <= 0 {
+			return nil, false
+		}
+		if name == "ln" {
+			return bigLog(x, prec), true
+		}
+		return bigQuo(bigLog(x, prec+16), bigLog(newBig(prec+16).SetInt64(10), prec+16), prec)
+
+	case "sqrt":
+		if x.Sign() < 0 {
+			return nil, false
+		}
+		if x.Sign() == 0 {
+			return newBig(prec), true
+		}
+		return newBig(prec).Sqrt(x), true
+
+	case "abs":
+		return newBig(prec).Abs(x), true
+	case "sign":
+		return newBig(prec).SetInt64(int64(x.Sign())), true
+	}
+
+	return nil, false
+}
+
+// bigMultiFunc is bigFunc for the functions with more than one argument.
+func bigMultiFunc(name string, x []*big.Float, prec uint) (z *big.Float, ok bool) {
+	wp := prec + 16
+
+	switch name {
+	case "log":
+		b, a := x[0], x[1]
+		if b.Sign() <= 0 || b.Cmp(bigOne(wp)) == 0 || a.Sign() <= 0 {
+			return nil, false
+		}
+		return bigQuo(bigLog(a, wp), bigLog(b, wp), prec)
+
+	case "atan2":
+		y, a := x[0], x[1]
+		if a.Sign() == 0 {
+			if y.Sign() == 0 {
+				return nil, false
+			}
+			r := bigPi(prec)
+			r.SetMantExp(r, -1)
+			if y.Sign() < 0 {
+				r.Neg(r)
+			}
+			return r, true
+		}
+
+		r := bigAtan(newBig(wp).Quo(y, a), wp)
+		if a.Sign() < 0 {
+			// Over on the left, atan(y/x) is pi out
+			if y.Sign() < 0 {
+				r.Sub(r, bigPi(wp))
+			} else {
+				r.Add(r, bigPi(wp))
+			}
+		}
+		return newBig(prec).Set(r), true
+
+	case "min", "max":
+		m := x[0]
+		for _, a := range x[1:] {
+			if c := a.Cmp(m); c < 0 && name == "min" || c > 0 && name == "max" {
+				m = a
+			}
+		}
+		return newBig(prec).Set(m), true
+
+	case "root":
+		n, a := x[0], x[1]
+		f, _ := n.Float64()
+		if n.Sign() == 0 || (a.Sign() < 0 && !(n.IsInt() && isOddInteger(f))) {
+			return nil, false
+		}
+		r, ok := bigPow(newBig(wp).Abs(a), newBig(wp).Quo(bigOne(wp), n), prec)
+		if ok && a.Sign() < 0 {
+			r.Neg(r)
+		}
+		return r, ok
+
+	case "hypot":
+		r := newBig(wp)
+		for _, a := range x {
+			r.Add(r, newBig(wp).Mul(a, a))
+		}
+		if r.Sign() == 0 {
+			return newBig(prec), true
+		}
+		return newBig(prec).Sqrt(r), true
+	}
+
+	return nil, false
+}
+
+func bigQuo(a, b *big.Float, prec uint) (*big.Float, bool) {
+	if b.Sign() == 0 {
+		return nil, false
+	}
+	return newBig(prec).Quo(a, b), true
+}
+
+// bigSmallArgPrec gives the precision needed to work out functions like
+// ln(1 + x) without losing the low bits of a tiny x.
+func bigSmallArgPrec(x *big.Float, prec uint) uint {
+	wp := prec + 16
+	if e := x.MantExp(nil); x.Sign() != 0 && e < 0 {
+		wp += uint(-e)
+	}
+	return wp
+}
+
+// bigPow works out a^b. ok is false if the result isn't a real number.
+func bigPow(a, b *big.Float, prec uint) (z *big.Float, ok bool) {
+	if a.Sign() == 0 {
+		switch b.Sign() {
+		case 1:
+			return newBig(prec), true
+		case 0:
+			return bigOne(prec), true
+		}
+		return nil, false
+	}
+
+	// Integer powers can be done exactly(ish) by repeated squaring
+	if n, acc := b.Int64(); b.IsInt() && acc == big.Exact {
+		neg := n < 0
+		if neg {
+			n = -n
+		}
+
+		wp := prec + 16 + uint(bitLen(uint64(n)))
+		z = bigOne(wp)
+		sq := newBig(wp).Set(a)
+		for ; n > 0; n >>= 1 {
+			if n&1 == 1 {
+				z.Mul(z, sq)
+			}
+			sq.Mul(sq, sq)
+		}
+
+		if neg {
+			z.Quo(bigOne(wp), z)
+		}
+		return newBig(prec).Set(z), true
+	}
+
+	if a.Sign() < 0 {
+		return nil, false
+	}
+
+	// a^b = e^(b*ln(a)). Errors in the exponent turn into relative errors in
+	// the result, so it needs as many extra bits as the exponent is big.
+	wp := prec + 16
+	y := newBig(wp).Mul(b, bigLog(a, wp))
+	if e := y.MantExp(nil); e > 0 && !bigExpOutOfRange(y) {
+		wp += uint(e)
+		y = newBig(wp).Mul(b, bigLog(a, wp))
+	}
+	return bigExp(y, prec), true
+}
+
+// bigFactorialExact is as far as bigFactorial multiplies everything out.
+// Past it the product takes too long, and Stirling's series is quick.
+const bigFactorialExact = 10000
+
+// bigFactorial works out n!. ok is false if n is too big for Stirling's
+// series to get to prec bits.
+func bigFactorial(n int64, prec uint) (z *big.Float, ok bool) {
+	if n <= bigFactorialExact {
+		return newBig(prec).SetInt(new(big.Int).MulRange(1, n)), true
+	}
+
+	// ln(n!) = (n + 1/2)ln(n) - n + ln(2pi)/2 + sum B_2k/(2k(2k - 1)n^(2k - 1))
+	// ln(n!) has bitLen(n) + 6 bits before the point, and all of them plus
+	// prec after it need to be right for e^ln(n!) to be
+	wp := prec + 32 + uint(bitLen(uint64(n)))
+	nf := newBig(wp).SetInt64(n)
+
+	r := newBig(wp).Add(nf, newBig(wp).SetFloat64(0.5))
+	r.Mul(r, bigLog(nf, wp))
+	r.Sub(r, nf)
+	twoPi := bigPi(wp)
+	twoPi = bigLog(twoPi.SetMantExp(twoPi, 1), wp)
+	r.Add(r, twoPi.SetMantExp(twoPi, -1))
+
+	bernoulli := bernoulliNumbers()
+	pow := newBig(wp).Set(nf)
+	n2 := newBig(wp).Mul(nf, nf)
+	for k := int64(1); ; k++ {
+		// The series never converges, but its terms get small enough long
+		// before they start growing again
+		if k > bigStirlingTerms {
+			return nil, false
+		}
+		term := newBig(wp).SetRat(bernoulli())
+		term.Quo(term, newBig(wp).SetInt64(2*k*(2*k-1)))
+		term.Quo(term, pow)
+		r.Add(r, term)
+		if term.MantExp(nil) < -int(wp) {
+			break
+		}
+		pow.Mul(pow, n2)
+	}
+
+	return bigExp(r, prec), true
+}
+
+// bigStirlingTerms is as many terms of Stirling's series as bigFactorial
+// will use, which is good for about 1900 bits past bigFactorialExact.
+const bigStirlingTerms = 100
+
+// bernoulliNumbers gives a function that returns B_2, B_4, B_6, ... in
+// turn, using the Akiyama-Tanigawa algorithm.
+func bernoulliNumbers() func() *big.Rat {
+	var a []*big.Rat
+	next := func() *big.Rat {
+		m := len(a)
+		a = append(a, big.NewRat(1, int64(m+1)))
+		for j := m; j > 0; j-- {
+			d := new(big.Rat).Sub(a[j-1], a[j])
+			a[j-1] = d.Mul(d, big.NewRat(int64(j), 1))
+		}
+		return a[0]
+	}
+
+	next()
+	return func() *big.Rat {
+		next()
+		return new(big.Rat).Set(next())
+	}
+}
+
+func bitLen(n uint64) int {
+	l := 0
+	for ; n > 0; n >>= 1 {
+		l++
+	}
+	return l
+}
+
+// bigExpOutOfRange reports whether e^x is too big or too small for a
+// big.Float. Past 2^32, x is well over ln(2^2147483647).
+func bigExpOutOfRange(x *big.Float) bool {
+	return x.IsInf() || x.MantExp(nil) > 32
+}
+
+// bigExp uses the Taylor series on x/2^n, which is small enough to converge
+// quickly, then squares the result n times. If e^x is out of range it gives
+// Inf or 0, the same as big.Float does when a multiplication overflows.
+func bigExp(x *big.Float, prec uint) *big.Float {
+	if x.Sign() == 0 {
+		return bigOne(prec)
+	}
+	if bigExpOutOfRange(x) {
+		if x.Sign() > 0 {
+			return newBig(prec).SetInf(false)
+		}
+		return newBig(prec)
+	}
+
+	n := 0
+	if e := x.MantExp(nil); e > -8 {
+		n = e + 8
+	}
+	wp := prec + 32 + uint(n)
+
+	r := newBig(wp).SetMantExp(x, -n)
+	sum := bigOne(wp)
+	term := bigOne(wp)
+	for k := int64(1); ; k++ {
+		term.Mul(term, r)
+		term.Quo(term, newBig(wp).SetInt64(k))
+		sum.Add(sum, term)
+		if term.Sign() == 0 || term.MantExp(nil) < sum.MantExp(nil)-int(wp) {
+			break
+		}
+	}
+
+	for i := 0; i < n; i++ {
+		sum.Mul(sum, sum)
+	}
+	return newBig(prec).Set(sum)
+}
+
+// bigLog splits x into m * 2^k with m between sqrt(1/2) and sqrt(2), then
+// uses ln(m) = 2*atanh((m - 1)/(m + 1)) which converges quickly for m near 1.
+func bigLog(x *big.Float, prec uint) *big.Float {
+	if x.IsInf() {
+		return newBig(prec).SetInf(false)
+	}
+	wp := prec + 32
+
+	m := newBig(wp)
+	k := x.MantExp(m)
+	if f, _ := m.Float64(); f < 0.7071 {
+		m.SetMantExp(m, 1)
+		k--
+	}
+
+	one := bigOne(wp)
+	z := newBig(wp).Sub(m, one)
+	z.Quo(z, newBig(wp).Add(m, one))
+	r := bigAtanhSeries(z, wp)
+	r.SetMantExp(r, 1)
+
+	if k != 0 {
+		ln2 := bigAtanhSeries(newBig(wp).Quo(one, newBig(wp).SetInt64(3)), wp)
+		ln2.SetMantExp(ln2, 1)
+		r.Add(r, ln2.Mul(ln2, newBig(wp).SetInt64(int64(k))))
+	}
+
+	return newBig(prec).Set(r)
+}
+
+// bigAtanhSeries sums z + z^3/3 + z^5/5 + ..., which only converges
+// quickly for small z.
+func bigAtanhSeries(z *big.Float, prec uint) *big.Float {
+	return bigOddSeries(z, prec, false)
+}
+
+// bigAtanSeries sums z - z^3/3 + z^5/5 - ..., which only converges quickly
+// for small z.
+func bigAtanSeries(z *big.Float, prec uint) *big.Float {
+	return bigOddSeries(z, prec, true)
+}
+
+func bigOddSeries(z *big.Float, prec uint, alternate bool) *big.Float {
+	z2 := newBig(prec).Mul(z, z)
+	if alternate {
+		z2.Neg(z2)
+	}
+
+	sum := newBig(prec).Set(z)
+	pow := newBig(prec).Set(z)
+	term := newBig(prec)
+	for k := int64(3); ; k += 2 {
+		pow.Mul(pow, z2)
+		term.Quo(pow, newBig(prec).SetInt64(k))
+		sum.Add(sum, term)
+		if term.Sign() == 0 || term.MantExp(nil) < sum.MantExp(nil)-int(prec) {
+			break
+		}
+	}
+	return sum
+}
+
+// bigPi uses Machin's formula: pi = 16*atan(1/5) - 4*atan(1/239)
+func bigPi(prec uint) *big.Float {
+	wp := prec + 16
+	one := bigOne(wp)
+
+	a := bigAtanSeries(newBig(wp).Quo(one, newBig(wp).SetInt64(5)), wp)
+	b := bigAtanSeries(newBig(wp).Quo(one, newBig(wp).SetInt64(239)), wp)
+	a.SetMantExp(a, 4)
+	b.SetMantExp(b, 2)
+
+	return newBig(prec).Sub(a, b)
+}
+
+// bigAtan halves the angle until the argument is small enough for the
+// Taylor series, using atan(x) = 2*atan(x/(1 + sqrt(1 + x^2))).
+func bigAtan(x *big.Float, prec uint) *big.Float {
+	if x.Sign() == 0 {
+		return newBig(prec)
+	}
+
+	wp := prec + 32
+	one := bigOne(wp)
+	a := newBig(wp).Abs(x)
+
+	// atan(x) = pi/2 - atan(1/x)
+	invert := a.Cmp(one) > 0
+	if invert {
+		a.Quo(one, a)
+	}
+
+	// a is 0 if x was infinite
+	halvings := 0
+	for a.Sign() != 0 && a.MantExp(nil) > -4 {
+		r := newBig(wp).Mul(a, a)
+		r.Add(r, one)
+		r.Sqrt(r)
+		r.Add(r, one)
+		a.Quo(a, r)
+		halvings++
+	}
+
+	r := bigAtanSeries(a, wp)
+	r.SetMantExp(r, halvings)
+
+	if invert {
+		halfPi := bigPi(wp)
+		halfPi.SetMantExp(halfPi, -1)
+		r.Sub(halfPi, r)
+	}
+	if x.Sign() < 0 {
+		r.Neg(r)
+	}
+
+	return newBig(prec).Set(r)
+}
+
+// asin(x) = atan(x/sqrt(1 - x^2))
+func bigAsin(x *big.Float, prec uint) (*big.Float, bool) {
+	wp := prec + 16
+	one := bigOne(wp)
+
+	switch newBig(wp).Abs(x).Cmp(one) {
+	case 1:
+		return nil, false
+	case 0:
+		r := bigPi(prec)
+		r.SetMantExp(r, -1)
+		if x.Sign() < 0 {
+			r.Neg(r)
+		}
+		return r, true
+	}
+
+	// 1 - x^2 = (1 - x)(1 + x), which doesn't cancel as badly for x near 1
+	d := newBig(wp).Sub(one, x)
+	d.Mul(d, newBig(wp).Add(one, x))
+	d.Sqrt(d)
+	return bigAtan(d.Quo(x, d), prec), true
+}
+
+// acos(x) = 2*atan(sqrt((1 - x)/(1 + x)))
+func bigAcos(x *big.Float, prec uint) (*big.Float, bool) {
+	wp := prec + 16
+	one := bigOne(wp)
+
+	if newBig(wp).Abs(x).Cmp(one) > 0 {
+		return nil, false
+	}
+	if x.Cmp(newBig(wp).Neg(one)) == 0 {
+		return bigPi(prec), true
+	}
+
+	r := newBig(wp).Sub(one, x)
+	r.Quo(r, newBig(wp).Add(one, x))
+	r.Sqrt(r)
+	r = bigAtan(r, wp)
+	return newBig(prec).SetMantExp(r, 1), true
+}
+
+// bigReduce takes x down to somewhere in [-pi, pi] by subtracting a
+// multiple of 2*pi. pi needs an extra bit for every bit of x's integer part,
+// or they'll all get lost in the subtraction.
+func bigReduce(x *big.Float, prec uint) *big.Float {
+	wp := prec + 32
+	if e := x.MantExp(nil); e > 0 {
+		wp += uint(e)
+	}
+
+	twoPi := bigPi(wp)
+	twoPi.SetMantExp(twoPi, 1)
+
+	k := newBig(wp).Quo(x, twoPi)
+	k.Add(k, newBig(wp).SetFloat64(0.5))
+	n, _ := k.Int(nil)
+	if k.Sign() < 0 && !k.IsInt() {
+		n.Sub(n, big.NewInt(1))
+	}
+
+	r := newBig(wp).SetInt(n)
+	r.Mul(r, twoPi)
+	return r.Sub(x, r)
+}
+
+func bigSin(x *big.Float, prec uint) *big.Float {
+	return bigSinCosSeries(bigReduce(x, prec), prec, true)
+}
+
+func bigCos(x *big.Float, prec uint) *big.Float {
+	return bigSinCosSeries(bigReduce(x, prec), prec, false)
+}
+
+// bigSinCosSeries sums the Taylor series for sin or cos, for x in [-pi, pi].
+func bigSinCosSeries(x *big.Float, prec uint, sin bool) *big.Float {
+	wp := prec + 32
+	x2 := newBig(wp).Mul(x, x)
+	x2.Neg(x2)
+
+	term := bigOne(wp)
+	k := int64(0)
+	if sin {
+		term.Set(x)
+		k = 1
+	}
+	sum := newBig(wp).Set(term)
+
+	for {
+		term.Mul(term, x2)
+		term.Quo(term, newBig(wp).SetInt64((k+1)*(k+2)))
+		k += 2
+		sum.Add(sum, term)
+		if term.Sign() == 0 || (sum.Sign() != 0 && term.MantExp(nil) < sum.MantExp(nil)-int(wp)) {
+			break
+		}
+	}
+
+	return newBig(prec).Set(sum)
+}
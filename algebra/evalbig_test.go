@@ -0,0 +1,116 @@
+package algebra
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+)
+
+func TestEvalBigConstants(t *testing.T) {
+	const prec = 200
+	for in, want := range map[string]string{
+		"pi":       "3.141592653589793238462643383279502884197169399375105820974944592307812",
+		"4atan(1)": "3.141592653589793238462643383279502884197169399375105820974944592307812",
+		"e":        "2.718281828459045235360287471352662497757247093699959574966967627724077",
+		"e^1":      "2.718281828459045235360287471352662497757247093699959574966967627724077",
+		"sqrt(2)":  "1.414213562373095048801688724209698078569671875376948073176679737990732",
+		"2^(1/2)":  "1.414213562373095048801688724209698078569671875376948073176679737990732",
+		"ln(2)":    "0.6931471805599453094172321214581765680755001343602552541206800094933936",
+		"ln(10)":   "2.302585092994045684017991454684364207601101488628772976033327900967573",
+		"sin(1)":   "0.8414709848078965066525023216302989996225630607983710656727517099919102",
+		"30!":      "265252859812191058636308480000000",
+		"tanh(0)":  "0",
+	} {
+		checkBig(t, in, want, prec)
+	}
+}
+
+func TestEvalBigFactorial(t *testing.T) {
+	// Past bigFactorialExact, n! comes from Stirling's series
+	for _, n := range []int64{bigFactorialExact + 1, 3 * bigFactorialExact} {
+		for _, prec := range []uint{53, 200, 1000} {
+			e := &Expression{"!", FUNC_POSTFIX, &Expression{big.NewInt(n).String(), NUMBER, nil, nil}, nil}
+			got, err := e.EvalBig(nil, prec)
+			if err != nil {
+				t.Fatalf("%d! to %d bits: %v", n, prec, err)
+			}
+			want := newBig(prec).SetInt(new(big.Int).MulRange(1, n))
+			if !bigClose(got, want, prec) {
+				t.Errorf("%d! to %d bits: got %v want %v", n, prec, got, want)
+			}
+		}
+	}
+}
+
+func TestEvalBigOverflow(t *testing.T) {
+	for _, in := range []string{
+		"2^(2^40)",
+		"ln(2^(2^40))",
+		"2^(2^40) - 2^(2^40)",
+		"0*2^(2^40)",
+		"sin(2^(2^40))",
+		"atan(2^(2^40))",
+		"sinh(1e10)",
+		"e^(2^(2^40))",
+		"99999999999!",
+		"(2^40)!",
+	} {
+		e, err := Parse(in)
+		if err != nil {
+			t.Fatal(in, err)
+		}
+		var overflow *OverflowError
+		if _, err := e.EvalBig(nil, 100); !errors.As(err, &overflow) {
+			t.Errorf("%s: got %v, want an overflow", in, err)
+		}
+	}
+
+	// These only overflow part way through
+	for in, want := range map[string]string{
+		"tanh(1e10)":   "1",
+		"tanh(-1e10)":  "-1",
+		"coth(1e10)":   "1",
+		"sech(1e10)":   "0",
+		"csch(-1e10)":  "0",
+		"e^(-(2^40))":  "0",
+		"2^(-(2^40))":  "0",
+		"atan2(1, 0)":  "1.570796326794896619231321691639751442098584699687552910487472296153906",
+		"acot(1e-300)": "1.570796326794896619231321691639751442098584699687552910487472296153906",
+	} {
+		checkBig(t, in, want, 100)
+	}
+}
+
+// checkBig checks that in comes out as want to within the last couple of
+// bits of prec
+func checkBig(t *testing.T, in, want string, prec uint) {
+	t.Helper()
+
+	e, err := Parse(in)
+	if err != nil {
+		t.Fatal(in, err)
+	}
+	got, err := e.EvalBig(nil, prec)
+	if err != nil {
+		t.Errorf("%s: %v", in, err)
+		return
+	}
+	w, _, err := big.ParseFloat(want, 10, prec+64, big.ToNearestEven)
+	if err != nil {
+		t.Fatal(want, err)
+	}
+	if !bigClose(got, w, prec) {
+		t.Errorf("%s: got %s want %s", in, got.Text('g', 40), want)
+	}
+}
+
+func bigClose(got, want *big.Float, prec uint) bool {
+	if got.Prec() != prec {
+		return false
+	}
+	diff := newBig(prec+64).Sub(got, want)
+	if want.Sign() == 0 {
+		return diff.Sign() == 0
+	}
+	return diff.Sign() == 0 || diff.MantExp(nil) <= want.MantExp(nil)-int(prec)+2
+}
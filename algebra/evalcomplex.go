@@ -0,0 +1,251 @@
+package algebra
+
+import (
+	"errors"
+	"math"
+	"math/cmplx"
+	"strconv"
+)
+
+// The inverse functions all use the principal branches from math/cmplx,
+// and the reciprocal ones are defined in terms of those, so acot(z) is
+// atan(1/z) and so on.
+var complexFuncs = map[string]func(complex128) complex128{
+	"sin": cmplx.Sin,
+	"cos": cmplx.Cos,
+	"tan": cmplx.Tan,
+	"sec": func(z complex128) complex128 { return 1 / cmplx.Cos(z) },
+	"csc": func(z complex128) complex128 { return 1 / cmplx.Sin(z) },
+	"cot": func(z complex128) complex128 { return cmplx.Cos(z) / cmplx.Sin(z) },
+
+	"asin": cmplx.Asin,
+	"acos": cmplx.Acos,
+	"atan": cmplx.Atan,
+	"asec": func(z complex128) complex128 { return cmplx.Acos(1 / z) },
+	"acsc": func(z complex128) complex128 { return cmplx.Asin(1 / z) },
+	"acot": func(z complex128) complex128 {
+		if z == 0 {
+			return math.Pi / 2
+		}
+		return cmplx.Atan(1 / z)
+	},
+
+	"sinh": cmplx.Sinh,
+	"cosh": cmplx.Cosh,
+	"tanh": cmplx.Tanh,
+	"sech": func(z complex128) complex128 { return 1 / cmplx.Cosh(z) },
+	"csch": func(z complex128) complex128 { return 1 / cmplx.Sinh(z) },
+	"coth": func(z complex128) complex128 { return cmplx.Cosh(z) / cmplx.Sinh(z) },
+
+	"asinh": cmplx.Asinh,
+	"acosh": cmplx.Acosh,
+	"atanh": cmplx.Atanh,
+	"asech": func(z complex128) complex128 { return cmplx.Acosh(1 / z) },
+	"acsch": func(z complex128) complex128 { return cmplx.Asinh(1 / z) },
+	"acoth": func(z complex128) complex128 { return cmplx.Atanh(1 / z) },
+
+	"ln":   cmplx.Log,
+	"log":  cmplx.Log10,
+	"sqrt": cmplx.Sqrt,
+	"abs":  func(z complex128) complex128 { return complex(cmplx.Abs(z), 0) },
+	"sign": func(z complex128) complex128 {
+		if z == 0 {
+			return 0
+		}
+		return z / complex(cmplx.Abs(z), 0)
+	},
+}
+
+// In the complex plane the only places these functions aren't defined are
+// their poles. Functions without any aren't listed.
+var complexDomains = map[string]func(complex128) bool{
+	"tan": func(z complex128) bool { return cmplx.Cos(z) != 0 },
+	"sec": func(z complex128) bool { return cmplx.Cos(z) != 0 },
+	"csc": func(z complex128) bool { return cmplx.Sin(z) != 0 },
+	"cot": func(z complex128) bool { return cmplx.Sin(z) != 0 },
+
+	"atan": func(z complex128) bool { return z != 1i && z != -1i },
+	"asec": func(z complex128) bool { return z != 0 },
+	"acsc": func(z complex128) bool { return z != 0 },
+	"acot": func(z complex128) bool { return z != 1i && z != -1i },
+
+	"tanh": func(z complex128) bool { return cmplx.Cosh(z) != 0 },
+	"sech": func(z complex128) bool { return cmplx.Cosh(z) != 0 },
+	"csch": func(z complex128) bool { return cmplx.Sinh(z) != 0 },
+	"coth": func(z complex128) bool { return cmplx.Sinh(z) != 0 },
+
+	"atanh": func(z complex128) bool { return z != 1 && z != -1 },
+	"asech": func(z complex128) bool { return z != 0 },
+	"acsch": func(z complex128) bool { return z != 0 },
+	"acoth": func(z complex128) bool { return z != 1 && z != -1 },
+
+	"ln":  func(z complex128) bool { return z != 0 },
+	"log": func(z complex128) bool { return z != 0 },
+}
+
+var complexConstants = map[string]complex128{
+	"e":  math.E,
+	"pi": math.Pi,
+	"i":  1i,
+}
+
+// EvalComplex works out the value of the expression as a complex number,
+// with i as the imaginary unit, looking up the values of any variables in
+// env.
+func (exp *Expression) EvalComplex(env map[string]complex128) (complex128, error) {
+	op := exp.Op
+
+	switch exp.Type {
+	case NUMBER:
+		x, err := strconv.ParseFloat(op, 64)
+		return complex(x, 0), err
+
+	case CONSTANT:
+		if c, ok := complexConstants[op]; ok {
+			return c, nil
+		}
+		return 0, errors.New("Unknown constant: " + op)
+
+	case VARIABLE:
+		if v, ok := env[op]; ok {
+			return v, nil
+		}
+		return 0, &UnboundVariableError{op}
+
+	case OP_LOW, OP_MED, OP_HIGH:
+		a, err := exp.Left.EvalComplex(env)
+		if err != nil {
+			return 0, err
+		}
+		b, err := exp.Right.EvalComplex(env)
+		if err != nil {
+			return 0, err
+		}
+
+		switch op {
+		case "+":
+			return a + b, nil
+		case "-":
+			return a - b, nil
+		case "*":
+			return a * b, nil
+		case "/":
+			if b == 0 {
+				return 0, complexDomainError(op, a, b)
+			}
+			return a / b, nil
+		case "^":
+			if a == 0 && (real(b) < 0 || (real(b) == 0 && imag(b) != 0)) {
+				return 0, complexDomainError(op, a, b)
+			}
+			return cmplx.Pow(a, b), nil
+		}
+		return 0, errors.New("Unknown operator: " + op)
+
+	case FUNC_PREFIX:
+		if exp.Left.Type == COMMA {
+			return exp.evalComplexMulti(env)
+		}
+
+		z, err := exp.Left.EvalComplex(env)
+		if err != nil {
+			return 0, err
+		}
+
+		name := canonicalFunc(op)
+		fn, ok := complexFuncs[name]
+		if !ok {
+			return 0, errors.New("Unknown function: " + op)
+		}
+		if inDomain, ok := complexDomains[name]; ok && !inDomain(z) {
+			return 0, complexDomainError(op, z)
+		}
+		return fn(z), nil
+
+	case NEGATE:
+		// 0 - z rather than -z, so that negating a real number doesn't give
+		// it an imaginary part of -0 and put it on the other side of the
+		// branch cuts
+		z, err := exp.Left.EvalComplex(env)
+		return 0 - z, err
+
+	case FUNC_POSTFIX:
+		z, err := exp.Left.EvalComplex(env)
+		if err != nil {
+			return 0, err
+		}
+
+		if op != "!" {
+			return 0, errors.New("Unknown function: " + op)
+		}
+		if imag(z) != 0 {
+			return 0, errors.New("Can't take the factorial of a complex number")
+		}
+		if !factorialDefined(real(z)) {
+			return 0, complexDomainError(op, z)
+		}
+		return complex(factorial(real(z)), 0), nil
+
+	case EQUALS:
+		return 0, errors.New("Can't evaluate an equation")
+	}
+
+	return 0, errors.New("Unknown operator: " + op)
+}
+
+// evalComplexMulti evaluates a function with more than one argument. log
+// and root use principal branches like everything else, but the others only
+// make sense for real numbers.
+func (exp *Expression) evalComplexMulti(env map[string]complex128) (complex128, error) {
+	args := exp.Args()
+	z := make([]complex128, len(args))
+	for i, arg := range args {
+		var err error
+		if z[i], err = arg.EvalComplex(env); err != nil {
+			return 0, err
+		}
+	}
+
+	switch name := canonicalFunc(exp.Op); name {
+	case "log":
+		if z[0] == 0 || z[0] == 1 || z[1] == 0 {
+			return 0, complexDomainError(exp.Op, z...)
+		}
+		return cmplx.Log(z[1]) / cmplx.Log(z[0]), nil
+
+	case "root":
+		if z[0] == 0 || (z[1] == 0 && real(z[0]) <= 0) {
+			return 0, complexDomainError(exp.Op, z...)
+		}
+		if z[0] == 2 {
+			return cmplx.Sqrt(z[1]), nil
+		}
+		return cmplx.Pow(z[1], 1/z[0]), nil
+
+	default:
+		fn, ok := realMultiFuncs[name]
+		if !ok {
+			return 0, errors.New("Unknown function: " + exp.Op)
+		}
+
+		x := make([]float64, len(z))
+		for i := range z {
+			if imag(z[i]) != 0 {
+				return 0, errors.New("Can't take the " + exp.Op + " of complex numbers")
+			}
+			x[i] = real(z[i])
+		}
+		if inDomain, ok := realMultiDomains[name]; ok && !inDomain(x) {
+			return 0, complexDomainError(exp.Op, z...)
+		}
+		return complex(fn(x), 0), nil
+	}
+}
+
+func complexDomainError(op string, args ...complex128) error {
+	strs := make([]string, len(args))
+	for i, arg := range args {
+		strs[i] = strconv.FormatComplex(arg, 'g', -1, 128)
+	}
+	return &DomainError{op, strs}
+}
@@ -0,0 +1,62 @@
+package algebra
+
+import (
+	"errors"
+	"math"
+	"math/cmplx"
+	"testing"
+)
+
+func TestEvalComplex(t *testing.T) {
+	// Along the branch cuts on the real axis, values are taken from above,
+	// the same as math/cmplx
+	acosh2 := math.Log(2 + math.Sqrt(3))
+	for _, test := range []struct {
+		in   string
+		want complex128
+	}{
+		{"e^(i*pi) + 1", 0},
+		{"i^2", -1},
+		{"i^i", complex(math.Exp(-math.Pi/2), 0)},
+		{"sqrt(-1)", 1i},
+		{"sqrt(-4)", 2i},
+		{"ln(-1)", complex(0, math.Pi)},
+		{"(-8)^(1/3)", complex(1, math.Sqrt(3))},
+		{"asin(2)", complex(math.Pi/2, acosh2)},
+		{"acos(2)", complex(0, -acosh2)},
+		{"acosh(0)", complex(0, math.Pi/2)},
+		{"atanh(2)", complex(math.Log(3)/2, math.Pi/2)},
+		{"(1 + i)*(1 - i)", 2},
+		{"abs(3 + 4i)", 5},
+	} {
+		e, err := Parse(test.in)
+		if err != nil {
+			t.Fatal(test.in, err)
+		}
+		got, err := e.EvalComplex(nil)
+		if err != nil {
+			t.Errorf("%s: %v", test.in, err)
+		} else if cmplx.Abs(got-test.want) > 1e-15*math.Max(1, cmplx.Abs(test.want)) {
+			t.Errorf("%s: got %v want %v", test.in, got, test.want)
+		}
+	}
+}
+
+func TestEvalComplexErrors(t *testing.T) {
+	for _, in := range []string{"ln(0)", "1/0", "0^-1"} {
+		e, err := Parse(in)
+		if err != nil {
+			t.Fatal(in, err)
+		}
+		var domain *DomainError
+		if _, err := e.EvalComplex(nil); !errors.As(err, &domain) {
+			t.Errorf("%s: got %v, want a domain error", in, err)
+		}
+	}
+
+	e, _ := Parse("z + 1")
+	var unbound *UnboundVariableError
+	if _, err := e.EvalComplex(map[string]complex128{"w": 1}); !errors.As(err, &unbound) || unbound.Name != "z" {
+		t.Errorf("z + 1: got %v, want z unbound", err)
+	}
+}
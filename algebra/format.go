@@ -0,0 +1,182 @@
+package algebra
+
+import (
+	"strings"
+)
+
+// FormatOptions control how Format writes an expression out
+type FormatOptions struct {
+	// ImplicitMultiply leaves out the * in products like 2x, 3sin(x) and
+	// (x+1)(x-1), wherever that reads back the same.
+	ImplicitMultiply bool
+
+	// Spacing is where the spaces go around operators
+	Spacing Spacing
+
+	// DoubleStar writes powers as x**2 rather than x^2
+	DoubleStar bool
+}
+
+// Spacing is where Format puts spaces around binary operators
+type Spacing uint8
+
+const (
+	// SpaceLow puts spaces around +, - and =, but not the rest: x^2 + 2*x
+	SpaceLow Spacing = iota
+
+	// SpaceNone leaves all the spaces out: x^2+2*x
+	SpaceNone
+
+	// SpaceAll puts spaces around every operator: x ^ 2 + 2 * x
+	SpaceAll
+)
+
+// Format writes the expression out with only the brackets it needs, so
+// that Parse reads it back in as the same tree.
+func (e *Expression) Format(opts FormatOptions) string {
+	return e.format(opts)
+}
+
+func (e *Expression) format(opts FormatOptions) string {
+	op := e.Op
+
+	switch e.Type {
+	case NUMBER, CONSTANT:
+		return op
+
+	case VARIABLE:
+		return formatName(op)
+
+	case FUNC_PREFIX:
+		args := e.Args()
+		list := make([]string, len(args))
+		for i, arg := range args {
+			list[i] = arg.format(opts)
+		}
+		sep := ", "
+		if opts.Spacing == SpaceNone {
+			sep = ","
+		}
+		return op + "(" + strings.Join(list, sep) + ")"
+
+	case NEGATE:
+		return "-" + e.operand(e.Left, opts, e.Left.printPower() <= bpNeg && !e.Left.isNegative())
+
+	case FUNC_POSTFIX:
+		return e.operand(e.Left, opts, e.Left.printPower() < bpPostfix) + op
+
+	case OP_LOW, OP_MED, OP_HIGH, EQUALS:
+		power := e.printPower()
+
+		// Everything's left associative apart from ^, so a chain of the
+		// same operator only needs brackets on the right, or on the left
+		// for ^. A negative on the right doesn't need any, as nothing
+		// that's left after its operand can be part of it.
+		var left, right string
+		if op == "^" {
+			left = e.operand(e.Left, opts, e.Left.printPower() <= power)
+			right = e.operand(e.Right, opts, e.Right.printPower() < power && !e.Right.isNegative())
+		} else {
+			left = e.operand(e.Left, opts, e.Left.printPower() < power)
+			right = e.operand(e.Right, opts, e.Right.printPower() <= power && !e.Right.isNegative())
+		}
+
+		if op == "*" && opts.ImplicitMultiply {
+			if sep, ok := juxtapose(left, right); ok {
+				return left + sep + right
+			}
+		}
+		if op == "^" && opts.DoubleStar {
+			op = "**"
+		}
+
+		if opts.Spacing == SpaceAll || (opts.Spacing == SpaceLow && power <= bpLow) {
+			return left + " " + op + " " + right
+		}
+		return left + op + right
+
+	case COMMA:
+		return e.Left.format(opts) + ", " + e.Right.format(opts)
+	}
+
+	return op
+}
+
+// formatName writes out a variable name. Greek letters are written as the
+// letters themselves, as Parse would read theta as t*h*e*t*a.
+func formatName(name string) string {
+	base, _ := splitName(name)
+	if r, ok := greekNames[base]; ok {
+		return string(r) + name[len(base):]
+	}
+	return name
+}
+
+// operand formats an operand of e, in brackets if it needs them
+func (e *Expression) operand(operand *Expression, opts FormatOptions, brackets bool) string {
+	if brackets {
+		return "(" + operand.format(opts) + ")"
+	}
+	return operand.format(opts)
+}
+
+// printPower gives the binding power of e as Format writes it out. An
+// operand needs brackets if its power is less than the operator it's in.
+// Functions are written with their arguments in brackets, so they're
+// nearly as tight as values, but a postfix function after one would end up
+// inside the brackets: sin(x)! is sin(x!).
+func (e *Expression) printPower() int {
+	switch e.Type {
+	case NUMBER:
+		if e.isNegative() {
+			return bpNeg
+		}
+		return bpPostfix + 1
+	case CONSTANT, VARIABLE:
+		return bpPostfix + 1
+	case FUNC_PREFIX:
+		return bpFunc
+	case FUNC_POSTFIX:
+		return bpPostfix
+	case NEGATE:
+		return bpNeg
+	case OP_HIGH:
+		return bpHigh
+	case OP_MED:
+		return bpMed
+	case OP_LOW:
+		return bpLow
+	case EQUALS:
+		return bpEquals
+	}
+	return bpNone
+}
+
+// isNegative reports whether e is written with a - at the front, which
+// makes it a unary minus as far as the parser's concerned
+func (e *Expression) isNegative() bool {
+	return e.Type == NEGATE || (e.Type == NUMBER && strings.HasPrefix(e.Op, "-"))
+}
+
+// juxtapose works out how to write left and right next to each other to
+// multiply them, giving the space to go between them. That only works if
+// right starts with a letter or bracket: numbers would run together, and a
+// - would be subtraction. Letters next to each other need a space, so they
+// don't make a word, and so does an e after a number, so it isn't read as
+// an exponent.
+func juxtapose(left, right string) (string, bool) {
+	if left == "" || right == "" {
+		return "", false
+	}
+	last, first := left[len(left)-1], right[0]
+
+	switch {
+	case first == '(':
+		return "", true
+	case !isLetter(first):
+		return "", false
+	case isLetter(last) || (isDigit(last) && (first == 'e' || first == 'E')):
+		return " ", true
+	}
+	return "", true
+}
@@ -0,0 +1,66 @@
+package algebra
+
+import "testing"
+
+var formatOptions = []FormatOptions{
+	{},
+	{Spacing: SpaceNone},
+	{Spacing: SpaceAll},
+	{ImplicitMultiply: true},
+	{ImplicitMultiply: true, Spacing: SpaceNone, DoubleStar: true},
+}
+
+func TestFormatRoundTrip(t *testing.T) {
+	for _, in := range append(roundTripCorpus, "θ*x", "Θ x + 2α", "sin(θ)^2 + cos(θ)^2", "2φ(x+1)") {
+		checkFormat(t, in, ParseOptions{})
+	}
+
+	// With Identifiers, Greek letters can have subscripts
+	for _, in := range []string{"theta_0 + θ_1", "2alpha1", "omega_max * t", "speed*time"} {
+		checkFormat(t, in, ParseOptions{Identifiers: true})
+	}
+}
+
+// checkFormat checks that Format writes the expression in out in a way
+// that parses back to the same tree, whatever the options
+func checkFormat(t *testing.T, in string, parseOpts ParseOptions) {
+	t.Helper()
+
+	e, err := ParseWithOptions(in, parseOpts)
+	if err != nil {
+		t.Fatal(in, err)
+	}
+	for _, opts := range formatOptions {
+		out := e.Format(opts)
+		back, err := ParseWithOptions(out, parseOpts)
+		if err != nil {
+			t.Errorf("%s: %+v: %s: %v", in, opts, out, err)
+		} else if !sameTree(e, back) {
+			t.Errorf("%s: %+v: %s reads back as %s", in, opts, out, back.Format(FormatOptions{}))
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	for in, want := range map[string]string{
+		"θ*x":             "θ*x",
+		"Θ + theta":       "Θ + t*h*e*t*a",
+		"x^2 + 2*x":       "x^2 + 2*x",
+		"(x + 1)*(x - 1)": "(x + 1)*(x - 1)",
+		"a - (b - c)":     "a - (b - c)",
+		"a - -b":          "a - -b",
+		"-(x^2)":          "-x^2",
+		"(-x)^2":          "(-x)^2",
+		"(sin x)!":        "(sin(x))!",
+		"a^b^c":           "a^b^c",
+		"(a^b)^c":         "(a^b)^c",
+	} {
+		e, err := Parse(in)
+		if err != nil {
+			t.Fatal(in, err)
+		}
+		if got := e.Format(FormatOptions{}); got != want {
+			t.Errorf("%s: got %s want %s", in, got, want)
+		}
+	}
+}
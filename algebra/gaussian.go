@@ -0,0 +1,159 @@
+package algebra
+
+import (
+	"math/big"
+)
+
+// A gaussian is a complex number with rational real and imaginary parts,
+// which is closed under + - * / and integer powers, so constant folding can
+// work with them exactly.
+type gaussian struct {
+	re, im *big.Rat
+}
+
+func newGaussian(re, im *big.Rat) gaussian {
+	return gaussian{re, im}
+}
+
+func (a gaussian) isZero() bool {
+	return a.re.Sign() == 0 && a.im.Sign() == 0
+}
+
+func (a gaussian) add(b gaussian) gaussian {
+	return gaussian{new(big.Rat).Add(a.re, b.re), new(big.Rat).Add(a.im, b.im)}
+}
+
+func (a gaussian) sub(b gaussian) gaussian {
+	return gaussian{new(big.Rat).Sub(a.re, b.re), new(big.Rat).Sub(a.im, b.im)}
+}
+
+// (a + bi)(c + di) = (ac - bd) + (ad + bc)i
+func (a gaussian) mul(b gaussian) gaussian {
+	re := new(big.Rat).Mul(a.re, b.re)
+	re.Sub(re, new(big.Rat).Mul(a.im, b.im))
+	im := new(big.Rat).Mul(a.re, b.im)
+	im.Add(im, new(big.Rat).Mul(a.im, b.re))
+	return gaussian{re, im}
+}
+
+// (a + bi)/(c + di) = (a + bi)(c - di)/(c^2 + d^2). b mustn't be zero.
+func (a gaussian) quo(b gaussian) gaussian {
+	norm := new(big.Rat).Mul(b.re, b.re)
+	norm.Add(norm, new(big.Rat).Mul(b.im, b.im))
+
+	conj := gaussian{b.re, new(big.Rat).Neg(b.im)}
+	z := a.mul(conj)
+	z.re.Quo(z.re, norm)
+	z.im.Quo(z.im, norm)
+	return z
+}
+
+// pow raises a to an integer power by repeated squaring. ok is false for
+// negative powers of zero.
+func (a gaussian) pow(n *big.Int) (z gaussian, ok bool) {
+	z = newGaussian(big.NewRat(1, 1), new(big.Rat))
+
+	// The powers of i just go round in a circle, so there's no need to
+	// multiply them out.
+	if a.re.Sign() == 0 && a.im.Cmp(big.NewRat(1, 1)) == 0 {
+		switch new(big.Int).Mod(n, big.NewInt(4)).Int64() {
+		case 1:
+			z.re, z.im = new(big.Rat), big.NewRat(1, 1)
+		case 2:
+			z.re = big.NewRat(-1, 1)
+		case 3:
+			z.re, z.im = new(big.Rat), big.NewRat(-1, 1)
+		}
+		return z, true
+	}
+
+	if n.Sign() < 0 {
+		if a.isZero() {
+			return z, false
+		}
+		a = z.quo(a)
+		n = new(big.Int).Neg(n)
+	}
+
+	sq := a
+	for i := 0; i < n.BitLen(); i++ {
+		if n.Bit(i) == 1 {
+			z = z.mul(sq)
+		}
+		sq = sq.mul(sq)
+	}
+	return z, true
+}
+
+func (e *Expression) isImaginaryUnit() bool {
+	return e.Type == CONSTANT && e.Op == "i"
+}
+
+func (e *Expression) isRational() bool {
+	return e.Type == NUMBER || e.isFrac()
+}
+
+// isImaginary matches i, -i and b*i, for rational b
+func (e *Expression) isImaginary() bool {
+	return e.isImaginaryUnit() || (e.Type == NEGATE && e.Left.isImaginaryUnit()) ||
+		(e.Op == "*" && e.Left.isRational() && e.Right.isImaginaryUnit())
+}
+
+// isGaussian matches the forms that gaussianToExp makes: a, b*i, -i, a + b*i
+// and a - b*i
+func (e *Expression) isGaussian() bool {
+	if e.isRational() || e.isImaginary() {
+		return true
+	}
+	return e.Type == OP_LOW && e.Left.isRational() && e.Right.isImaginary()
+}
+
+func (e *Expression) getGaussian() gaussian {
+	switch {
+	case e.isRational():
+		return newGaussian(e.getFrac(), new(big.Rat))
+
+	case e.isImaginaryUnit():
+		return newGaussian(new(big.Rat), big.NewRat(1, 1))
+
+	case e.Type == NEGATE:
+		return newGaussian(new(big.Rat), big.NewRat(-1, 1))
+
+	case e.isImaginary():
+		return newGaussian(new(big.Rat), e.Left.getFrac())
+	}
+
+	z := e.Left.getGaussian()
+	if e.Op == "+" {
+		return z.add(e.Right.getGaussian())
+	}
+	return z.sub(e.Right.getGaussian())
+}
+
+// gaussianToExp writes a gaussian out in the form a + b*i, leaving out
+// whichever bits of that are zero or one, and writing -1*i as -i.
+func gaussianToExp(z gaussian) *Expression {
+	if z.im.Sign() == 0 {
+		return ratToExp(z.re)
+	}
+
+	im := new(big.Rat).Set(z.im)
+	op := "+"
+	if z.re.Sign() != 0 && im.Sign() < 0 {
+		op = "-"
+		im.Neg(im)
+	}
+
+	imExp := &Expression{"i", CONSTANT, nil, nil}
+	switch {
+	case im.Cmp(big.NewRat(-1, 1)) == 0:
+		imExp = neg(imExp)
+	case im.Cmp(big.NewRat(1, 1)) != 0:
+		imExp = mul(ratToExp(im), imExp)
+	}
+
+	if z.re.Sign() == 0 {
+		return imExp
+	}
+	return &Expression{op, OP_LOW, ratToExp(z.re), imExp}
+}
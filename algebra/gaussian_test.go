@@ -0,0 +1,38 @@
+package algebra
+
+import "testing"
+
+func TestGaussianFolding(t *testing.T) {
+	for in, want := range map[string]string{
+		"(1+2i)*(3-i)": "5 + 5*i",
+		"1/(1+i)":      "1/2 - 1/2*i",
+		"(1+i)^2":      "2*i",
+		"(1+i)^(-2)":   "-1/2*i",
+		"3-i":          "3 - i",
+		"-i":           "-i",
+		"i^0":          "1",
+		"i^1":          "i",
+		"i^2":          "-1",
+		"i^3":          "-i",
+		"i^4":          "1",
+		"i^-1":         "-i",
+		"i^-2":         "-1",
+		"2 - 3i - 2":   "-3*i",
+		"i^3*i":        "1",
+		"(-i)^2":       "-1",
+		"(-i)^3":       "i",
+		"1/(-i)":       "i",
+		"-i*2":         "-2*i",
+		"i^3 + 1":      "1 - i",
+		"-(-i)":        "i",
+		"2 + (-i)":     "2 - i",
+	} {
+		e, err := Parse(in)
+		if err != nil {
+			t.Fatal(in, err)
+		}
+		if got := e.Simplify().Format(FormatOptions{}); got != want {
+			t.Errorf("%s: got %s want %s", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package algebra
+
+import (
+	"errors"
+	"math"
+	"strconv"
+)
+
+// EvalGradient works out the value of the expression and its partial
+// derivatives with respect to every variable in env, using reverse mode
+// differentiation: one pass forwards over the tree records each node's value
+// and the derivatives of that node with respect to its operands, then one
+// pass backwards accumulates how much the result depends on each node. This
+// costs about the same however many variables there are, unlike calling
+// Differentiate or EvalDerivative for each of them.
+func (exp *Expression) EvalGradient(env map[string]float64) (f float64, grad map[string]float64, err error) {
+	t := &tape{env: env, vars: make(map[string]int)}
+	top, err := t.record(exp)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	adjoints := t.adjoints(top)
+	grad = make(map[string]float64, len(env))
+	for name := range env {
+		grad[name] = 0
+		if i, ok := t.vars[name]; ok {
+			grad[name] = adjoints[i]
+		}
+	}
+	return t.nodes[top].val, grad, nil
+}
+
+// A tapeNode is a node of the expression with its value worked out. Its
+// operands are earlier nodes on the tape, and partials holds the derivative
+// of this node with respect to each of them.
+type tapeNode struct {
+	val      float64
+	args     []int
+	partials []float64
+
+	// active is set if the node depends on any variables. Derivatives
+	// aren't propagated to nodes that don't, which also saves working out
+	// ones that don't exist, like the derivative of a^b with respect to b
+	// for negative a.
+	active bool
+}
+
+type tape struct {
+	env   map[string]float64
+	vars  map[string]int
+	nodes []tapeNode
+}
+
+// adjoints works out how much the node at top depends on it and each node
+// before it on the tape
+func (t *tape) adjoints(top int) []float64 {
+	adjoints := make([]float64, top+1)
+	adjoints[top] = 1
+	for i := top; i >= 0; i-- {
+		node := t.nodes[i]
+		for j, arg := range node.args {
+			if t.nodes[arg].active {
+				adjoints[arg] += adjoints[i] * node.partials[j]
+			}
+		}
+	}
+	return adjoints
+}
+
+// sameGradient reports whether the nodes at i and j change at the same rate
+// with respect to every variable recorded so far
+func (t *tape) sameGradient(i, j int) bool {
+	a, b := t.adjoints(i), t.adjoints(j)
+	for _, v := range t.vars {
+		var da, db float64
+		if v <= i {
+			da = a[v]
+		}
+		if v <= j {
+			db = b[v]
+		}
+		if da != db {
+			return false
+		}
+	}
+	return true
+}
+
+func (t *tape) push(node tapeNode) int {
+	t.nodes = append(t.nodes, node)
+	return len(t.nodes) - 1
+}
+
+// record adds exp and everything under it to the tape, returning the
+// index of exp's node.
+func (t *tape) record(exp *Expression) (int, error) {
+	op := exp.Op
+
+	switch exp.Type {
+	case NUMBER:
+		x, err := strconv.ParseFloat(op, 64)
+		if err != nil {
+			return 0, err
+		}
+		return t.push(tapeNode{val: x}), nil
+
+	case CONSTANT:
+		if x, ok := realConstants[op]; ok {
+			return t.push(tapeNode{val: x}), nil
+		}
+		return 0, errors.New("Constant has no real value: " + op)
+
+	case VARIABLE:
+		// Every use of a variable shares one node, so its adjoint ends up
+		// as the whole partial derivative
+		if i, ok := t.vars[op]; ok {
+			return i, nil
+		}
+		x, ok := t.env[op]
+		if !ok {
+			return 0, &UnboundVariableError{op}
+		}
+		t.vars[op] = t.push(tapeNode{val: x, active: true})
+		return t.vars[op], nil
+
+	case OP_LOW, OP_MED, OP_HIGH:
+		l, err := t.record(exp.Left)
+		if err != nil {
+			return 0, err
+		}
+		r, err := t.record(exp.Right)
+		if err != nil {
+			return 0, err
+		}
+		a, b := t.nodes[l].val, t.nodes[r].val
+
+		switch op {
+		case "+", "-", "*", "/", "^":
+		default:
+			return 0, errors.New("Unknown operator: " + op)
+		}
+		if !realOpDefined(op, a, b) {
+			return 0, realDomainError(op, a, b)
+		}
+
+		node := tapeNode{
+			val:      realOp(op, a, b),
+			args:     []int{l, r},
+			partials: make([]float64, 2),
+			active:   t.nodes[l].active || t.nodes[r].active,
+		}
+		switch op {
+		case "+":
+			node.partials[0], node.partials[1] = 1, 1
+		case "-":
+			node.partials[0], node.partials[1] = 1, -1
+		case "*":
+			node.partials[0], node.partials[1] = b, a
+		case "/":
+			node.partials[0], node.partials[1] = 1/b, -a/(b*b)
+		case "^":
+			if t.nodes[l].active {
+				node.partials[0] = b * math.Pow(a, b-1)
+			}
+			if t.nodes[r].active {
+				node.partials[1] = node.val * math.Log(a)
+			}
+		}
+		return t.push(node), nil
+
+	case FUNC_PREFIX:
+		if exp.Left.Type == COMMA {
+			return t.recordMulti(exp)
+		}
+
+		i, err := t.record(exp.Left)
+		if err != nil {
+			return 0, err
+		}
+		x := t.nodes[i].val
+
+		name := canonicalFunc(op)
+		fn, ok := realFuncs[name]
+		if !ok {
+			return 0, errors.New("Unknown function: " + op)
+		}
+		if inDomain, ok := realDomains[name]; ok && !inDomain(x) {
+			return 0, realDomainError(op, x)
+		}
+
+		node := tapeNode{val: fn(x), args: []int{i}, partials: make([]float64, 1), active: t.nodes[i].active}
+		if node.active {
+			node.partials[0] = realDerivs[name](x)
+		}
+		return t.push(node), nil
+
+	case NEGATE:
+		i, err := t.record(exp.Left)
+		if err != nil {
+			return 0, err
+		}
+		return t.push(tapeNode{
+			val:      -t.nodes[i].val,
+			args:     []int{i},
+			partials: []float64{-1},
+			active:   t.nodes[i].active,
+		}), nil
+
+	case FUNC_POSTFIX:
+		i, err := t.record(exp.Left)
+		if err != nil {
+			return 0, err
+		}
+		x := t.nodes[i].val
+
+		if op != "!" {
+			return 0, errors.New("Unknown function: " + op)
+		}
+		if !factorialDefined(x) {
+			return 0, realDomainError(op, x)
+		}
+		if t.nodes[i].active {
+			return 0, errors.New("Can't differentiate: " + op)
+		}
+		return t.push(tapeNode{val: factorial(x)}), nil
+
+	case EQUALS:
+		return 0, errors.New("Can't evaluate an equation")
+	}
+
+	return 0, errors.New("Unknown operator: " + op)
+}
+
+// recordMulti adds a function with more than one argument to the tape
+func (t *tape) recordMulti(exp *Expression) (int, error) {
+	args := exp.Args()
+	node := tapeNode{args: make([]int, len(args))}
+	vals := make([]float64, len(args))
+	for i, arg := range args {
+		j, err := t.record(arg)
+		if err != nil {
+			return 0, err
+		}
+		node.args[i] = j
+		vals[i] = t.nodes[j].val
+		node.active = node.active || t.nodes[j].active
+	}
+
+	name := canonicalFunc(exp.Op)
+	fn, ok := realMultiFuncs[name]
+	if !ok {
+		return 0, errors.New("Unknown function: " + exp.Op)
+	}
+	if inDomain, ok := realMultiDomains[name]; ok && !inDomain(vals) {
+		return 0, realDomainError(exp.Op, vals...)
+	}
+
+	node.val = fn(vals)
+	if node.active && (name == "min" || name == "max") {
+		// min and max only depend on the argument they pick. Where that's
+		// more than one, they have to be changing at the same rate, the same
+		// as for EvalDual.
+		picked := extremes(name, vals)
+		node.partials = make([]float64, len(args))
+		if len(picked) == 0 {
+			for i := range node.partials {
+				node.partials[i] = math.NaN()
+			}
+		} else {
+			for _, i := range picked[1:] {
+				if !t.sameGradient(node.args[picked[0]], node.args[i]) {
+					return 0, errors.New("Can't differentiate " + exp.Op + " where its arguments are equal")
+				}
+			}
+			node.partials[picked[0]] = 1
+		}
+	} else if node.active {
+		derivs, ok := realMultiDerivs[name]
+		if !ok {
+			return 0, errors.New("Can't differentiate: " + exp.Op)
+		}
+
+		// Only the partials for arguments that depend on the variables get
+		// used, and the others might not exist
+		node.partials = derivs(vals)
+	}
+	return t.push(node), nil
+}
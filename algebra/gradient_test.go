@@ -0,0 +1,94 @@
+package algebra
+
+import (
+	"math"
+	"testing"
+)
+
+// EvalGradient should agree with EvalDerivative, and with evaluating
+// Differentiate's result, everywhere they're defined.
+func TestEvalGradientMatchesDerivative(t *testing.T) {
+	exps := append([]string{}, dualExpressions...)
+	for name := range realDerivs {
+		exps = append(exps, name+"(x^2 + y)", name+"(0.4x)", name+"(1/(3x))", name+"(x + 1)")
+	}
+
+	for _, s := range exps {
+		e, err := Parse(s)
+		if err != nil {
+			t.Fatal(s, err)
+		}
+		for _, p := range dualPoints {
+			checkGradient(t, e, s, map[string]float64{"x": p[0], "y": p[1]})
+		}
+	}
+}
+
+// Where min or max's arguments tie, EvalGradient should give a gradient
+// exactly when EvalDerivative gives a derivative for every variable.
+func TestEvalGradientTies(t *testing.T) {
+	for _, s := range []string{
+		"min(x, x)", "max(x, x)", "max(x, y)", "min(x, y, x)", "max(x^2, x*x)",
+		"min(2x, x + x, y)", "max(1, 1, x)", "max(1, x*0, y)", "min(x, 1)", "max(x*y, y)",
+	} {
+		e, err := Parse(s)
+		if err != nil {
+			t.Fatal(s, err)
+		}
+		checkGradient(t, e, s, map[string]float64{"x": 1, "y": 1})
+	}
+}
+
+func checkGradient(t *testing.T, e *Expression, s string, env map[string]float64) {
+	t.Helper()
+
+	want, err := e.Eval(env)
+	if err != nil {
+		if _, _, err := e.EvalGradient(env); err == nil {
+			t.Errorf("%s at %v: Eval failed but EvalGradient didn't", s, env)
+		}
+		return
+	}
+
+	wantGrad := map[string]float64{}
+	var wantErr error
+	for name := range env {
+		_, d, err := e.EvalDerivative(env, name)
+		if err != nil {
+			wantErr = err
+		}
+		wantGrad[name] = d
+
+		// Differentiate agrees too, apart from at ties and where the
+		// derivative goes off to infinity
+		dexp, err := e.Differentiate(name)
+		if err != nil {
+			t.Fatalf("d/d%s %s: %v", name, s, err)
+		}
+		if v, err := dexp.Eval(env); err == nil && !math.IsNaN(v) && wantErr == nil && !gradientEqual(v, d) {
+			t.Errorf("d/d%s %s at %v: Differentiate gives %v, EvalDerivative %v", name, s, env, v, d)
+		}
+	}
+
+	got, grad, err := e.EvalGradient(env)
+	switch {
+	case wantErr != nil:
+		if err == nil {
+			t.Errorf("%s at %v: got %v, want an error like %v", s, env, grad, wantErr)
+		}
+	case err != nil:
+		t.Errorf("%s at %v: %v", s, env, err)
+	case !approxEqual(got, want):
+		t.Errorf("%s at %v: got %v want %v", s, env, got, want)
+	default:
+		for name, d := range wantGrad {
+			if !gradientEqual(grad[name], d) {
+				t.Errorf("d/d%s %s at %v: got %v want %v", name, s, env, grad[name], d)
+			}
+		}
+	}
+}
+
+func gradientEqual(a, b float64) bool {
+	return a == b || approxEqual(a, b) || math.IsNaN(a) && math.IsNaN(b)
+}
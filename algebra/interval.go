@@ -0,0 +1,630 @@
+package algebra
+
+import (
+	"errors"
+	"math"
+	"math/big"
+	"strconv"
+)
+
+// Interval is the closed interval [Lo, Hi]. Either end can be infinite.
+type Interval struct {
+	Lo, Hi float64
+}
+
+func (iv Interval) String() string {
+	return "[" + strconv.FormatFloat(iv.Lo, 'g', -1, 64) + ", " + strconv.FormatFloat(iv.Hi, 'g', -1, 64) + "]"
+}
+
+var wholeLine = Interval{math.Inf(-1), math.Inf(1)}
+
+// EvalInterval works out an interval that's guaranteed to contain every
+// value the expression takes when each variable ranges over its interval in
+// env. The ends of every result are rounded outwards, so floating point
+// errors can only make the enclosure looser, never wrong.
+//
+// Where a function is only defined on part of an interval, the rest is
+// ignored, so ln([-1, 1]) is [-Inf, 0]. It's an error if it isn't defined
+// anywhere on the interval.
+func (exp *Expression) EvalInterval(env map[string]Interval) (Interval, error) {
+	op := exp.Op
+
+	switch exp.Type {
+	case NUMBER:
+		x, err := strconv.ParseFloat(op, 64)
+		if err != nil {
+			return Interval{}, err
+		}
+
+		// Numbers like 0.1 can't be stored exactly, so they need widening
+		if exact, ok := new(big.Rat).SetString(op); ok && new(big.Rat).SetFloat64(x).Cmp(exact) == 0 {
+			return Interval{x, x}, nil
+		}
+		return outward(x, x, 1), nil
+
+	case CONSTANT:
+		if x, ok := realConstants[op]; ok {
+			return outward(x, x, 1), nil
+		}
+		return Interval{}, errors.New("Constant has no real value: " + op)
+
+	case VARIABLE:
+		if v, ok := env[op]; ok {
+			return v, nil
+		}
+		return Interval{}, &UnboundVariableError{op}
+
+	case OP_LOW, OP_MED, OP_HIGH:
+		a, err := exp.Left.EvalInterval(env)
+		if err != nil {
+			return Interval{}, err
+		}
+		b, err := exp.Right.EvalInterval(env)
+		if err != nil {
+			return Interval{}, err
+		}
+
+		switch op {
+		case "+":
+			return Interval{addDown(a.Lo, b.Lo), addUp(a.Hi, b.Hi)}, nil
+		case "-":
+			return Interval{addDown(a.Lo, -b.Hi), addUp(a.Hi, -b.Lo)}, nil
+		case "*":
+			return intervalMul(a, b), nil
+		case "/":
+			if z, ok := intervalDiv(a, b); ok {
+				return z, nil
+			}
+			return Interval{}, intervalDomainError(op, a, b)
+		case "^":
+			if z, ok := intervalPow(a, b); ok {
+				return z, nil
+			}
+			return Interval{}, intervalDomainError(op, a, b)
+		}
+		return Interval{}, errors.New("Unknown operator: " + op)
+
+	case FUNC_PREFIX:
+		if exp.Left.Type == COMMA {
+			args := exp.Args()
+			x := make([]Interval, len(args))
+			for i, arg := range args {
+				var err error
+				if x[i], err = arg.EvalInterval(env); err != nil {
+					return Interval{}, err
+				}
+			}
+
+			name := canonicalFunc(op)
+			if _, ok := realMultiFuncs[name]; !ok {
+				return Interval{}, errors.New("Unknown function: " + op)
+			}
+			if z, ok := intervalMultiFunc(name, x); ok {
+				return z, nil
+			}
+			return Interval{}, intervalDomainError(op, x...)
+		}
+
+		x, err := exp.Left.EvalInterval(env)
+		if err != nil {
+			return Interval{}, err
+		}
+
+		name := canonicalFunc(op)
+		if _, ok := realFuncs[name]; !ok {
+			return Interval{}, errors.New("Unknown function: " + op)
+		}
+		if z, ok := intervalFunc(name, x); ok {
+			return z, nil
+		}
+		return Interval{}, intervalDomainError(op, x)
+
+	case NEGATE:
+		x, err := exp.Left.EvalInterval(env)
+		return Interval{-x.Hi, -x.Lo}, err
+
+	case FUNC_POSTFIX:
+		x, err := exp.Left.EvalInterval(env)
+		if err != nil {
+			return Interval{}, err
+		}
+
+		if op != "!" {
+			return Interval{}, errors.New("Unknown function: " + op)
+		}
+		return intervalFactorial(x), nil
+
+	case EQUALS:
+		return Interval{}, errors.New("Can't evaluate an equation")
+	}
+
+	return Interval{}, errors.New("Unknown operator: " + op)
+}
+
+func intervalDomainError(op string, args ...Interval) error {
+	strs := make([]string, len(args))
+	for i, arg := range args {
+		strs[i] = arg.String()
+	}
+	return &DomainError{op, strs}
+}
+
+// outward widens [lo, hi] by ulps units in the last place at each end, to
+// cover the rounding error in working them out.
+func outward(lo, hi float64, ulps int) Interval {
+	for i := 0; i < ulps; i++ {
+		lo = math.Nextafter(lo, math.Inf(-1))
+		hi = math.Nextafter(hi, math.Inf(1))
+	}
+	return Interval{lo, hi}
+}
+
+// The math package's functions aren't always correctly rounded, so their
+// results get widened a bit more.
+const libraryUlps = 2
+
+// clip cuts iv down to the part of it that's inside [lo, hi]. ok is false
+// if they don't overlap at all.
+func clip(iv Interval, lo, hi float64) (Interval, bool) {
+	iv = Interval{math.Max(iv.Lo, lo), math.Min(iv.Hi, hi)}
+	return iv, iv.Lo <= iv.Hi
+}
+
+// monotonic applies an increasing function to both ends of iv, or a
+// decreasing one with the ends swapped round.
+func monotonic(fn func(float64) float64, iv Interval, increasing bool) Interval {
+	if increasing {
+		return outward(fn(iv.Lo), fn(iv.Hi), libraryUlps)
+	}
+	return outward(fn(iv.Hi), fn(iv.Lo), libraryUlps)
+}
+
+// The arithmetic operators can tell whether their result was rounded, and
+// which way, using error-free transformations, so exact results (like
+// integer arithmetic) don't need widening.
+
+// addError gives the rounding error in s = a + b, using Knuth's TwoSum.
+func addError(a, b, s float64) float64 {
+	bb := s - a
+	return (a - (s - bb)) + (b - bb)
+}
+
+func addDown(a, b float64) float64 {
+	s := a + b
+	if addError(a, b, s) < 0 {
+		return math.Nextafter(s, math.Inf(-1))
+	}
+	return s
+}
+
+func addUp(a, b float64) float64 {
+	s := a + b
+	if addError(a, b, s) > 0 {
+		return math.Nextafter(s, math.Inf(1))
+	}
+	return s
+}
+
+// mulRounded works out a * b rounded down and up. 0 * Inf is taken to be
+// zero, which is the limit whichever way the ends are approached.
+func mulRounded(a, b float64) (lo, hi float64) {
+	p := a * b
+	if math.IsNaN(p) {
+		return 0, 0
+	}
+	lo, hi = p, p
+	if err := math.FMA(a, b, -p); err < 0 {
+		lo = math.Nextafter(p, math.Inf(-1))
+	} else if err > 0 {
+		hi = math.Nextafter(p, math.Inf(1))
+	}
+	return lo, hi
+}
+
+// divRounded works out a / b rounded down and up. ok is false for Inf/Inf.
+func divRounded(a, b float64) (lo, hi float64, ok bool) {
+	q := a / b
+	if math.IsNaN(q) {
+		return 0, 0, false
+	}
+	lo, hi = q, q
+	if math.IsInf(a, 0) || math.IsInf(b, 0) {
+		return lo, hi, true
+	}
+
+	// a/b = q + r/b
+	r := math.FMA(-q, b, a)
+	if r != 0 {
+		if (r < 0) != (b < 0) {
+			lo = math.Nextafter(q, math.Inf(-1))
+		} else {
+			hi = math.Nextafter(q, math.Inf(1))
+		}
+	}
+	return lo, hi, true
+}
+
+func intervalMul(a, b Interval) Interval {
+	z := Interval{math.Inf(1), math.Inf(-1)}
+	for _, x := range []float64{a.Lo, a.Hi} {
+		for _, y := range []float64{b.Lo, b.Hi} {
+			lo, hi := mulRounded(x, y)
+			z = Interval{math.Min(z.Lo, lo), math.Max(z.Hi, hi)}
+		}
+	}
+	return z
+}
+
+// intervalDiv divides a by b. When b has zero at one end, the result is
+// only unbounded on one side, depending on the sign of a. ok is false if
+// b is just zero.
+func intervalDiv(a, b Interval) (Interval, bool) {
+	inf := math.Inf(1)
+
+	switch {
+	case b.Lo == 0 && b.Hi == 0:
+		return Interval{}, false
+
+	case b.Lo > 0 || b.Hi < 0:
+		z := Interval{inf, -inf}
+		for _, x := range []float64{a.Lo, a.Hi} {
+			for _, y := range []float64{b.Lo, b.Hi} {
+				if lo, hi, ok := divRounded(x, y); ok {
+					z = Interval{math.Min(z.Lo, lo), math.Max(z.Hi, hi)}
+				}
+			}
+		}
+		return z, true
+
+	case b.Lo == 0:
+		switch {
+		case a.Lo > 0:
+			lo, _, _ := divRounded(a.Lo, b.Hi)
+			return Interval{lo, inf}, true
+		case a.Hi < 0:
+			_, hi, _ := divRounded(a.Hi, b.Hi)
+			return Interval{-inf, hi}, true
+		}
+
+	case b.Hi == 0:
+		switch {
+		case a.Lo > 0:
+			_, hi, _ := divRounded(a.Lo, b.Lo)
+			return Interval{-inf, hi}, true
+		case a.Hi < 0:
+			lo, _, _ := divRounded(a.Hi, b.Lo)
+			return Interval{lo, inf}, true
+		}
+	}
+
+	// Zero is inside b, so the result is the union of two unbounded
+	// intervals. The smallest single interval covering that is everything.
+	return wholeLine, true
+}
+
+func intervalPow(a, b Interval) (Interval, bool) {
+	// Integer powers are defined for negative numbers too
+	if b.Lo == b.Hi && b.Lo == math.Trunc(b.Lo) && math.Abs(b.Lo) < 1<<53 {
+		n := b.Lo
+		switch {
+		case n == 0:
+			return Interval{1, 1}, true
+
+		case n < 0:
+			p, _ := intervalPow(a, Interval{-n, -n})
+			return intervalDiv(Interval{1, 1}, p)
+
+		case math.Mod(n, 2) == 1:
+			pow := func(x float64) float64 { return math.Pow(x, n) }
+			return monotonic(pow, a, true), true
+		}
+
+		// Even powers go down to zero then back up again
+		lo, hi := math.Abs(a.Lo), math.Abs(a.Hi)
+		if lo > hi {
+			lo, hi = hi, lo
+		}
+		if a.Lo <= 0 && a.Hi >= 0 {
+			lo = 0
+		}
+		z := outward(math.Pow(lo, n), math.Pow(hi, n), libraryUlps)
+		z.Lo = math.Max(z.Lo, 0)
+		return z, true
+	}
+
+	// Negative numbers can still be raised to any integers in b, and
+	// whatever the sign comes out, it's no bigger than |a|^b
+	if a.Lo < 0 && math.Ceil(b.Lo) <= b.Hi {
+		m, _ := intervalPow(Interval{math.Max(-a.Hi, 0), -a.Lo}, b)
+		z := Interval{-m.Hi, m.Hi}
+		if a.Hi >= 0 {
+			if pos, ok := intervalPow(Interval{0, a.Hi}, b); ok {
+				z = Interval{math.Min(z.Lo, pos.Lo), math.Max(z.Hi, pos.Hi)}
+			}
+		}
+		return z, true
+	}
+
+	// Otherwise a^b = e^(b*ln(a)), which is only defined for a >= 0
+	a, ok := clip(a, 0, math.Inf(1))
+	if !ok {
+		return Interval{}, false
+	}
+	if a.Hi == 0 {
+		// 0^b is 0 for b > 0 and 1 for b = 0
+		switch {
+		case b.Hi < 0:
+			return Interval{}, false
+		case b.Lo > 0:
+			return Interval{0, 0}, true
+		case b.Hi == 0:
+			return Interval{1, 1}, true
+		}
+		return Interval{0, 1}, true
+	}
+
+	ln, _ := intervalFunc("ln", a)
+	z := monotonic(math.Exp, intervalMul(b, ln), true)
+	z.Lo = math.Max(z.Lo, 0)
+	return z, true
+}
+
+// containsPeriodic reports whether any of the points offset + k*period are
+// in [lo, hi]. It errs on the side of saying yes, which only makes the
+// trig functions' enclosures a little looser.
+func containsPeriodic(iv Interval, offset, period float64) bool {
+	if iv.Hi-iv.Lo >= period || math.IsInf(iv.Lo, 0) || math.IsInf(iv.Hi, 0) {
+		return true
+	}
+	k := math.Floor((iv.Hi-offset)/period + 1e-9)
+	slack := 1e-9 * math.Max(1, math.Abs(iv.Lo))
+	return offset+k*period >= iv.Lo-slack
+}
+
+// intervalFunc applies one of the (canonically named) prefix functions to
+// iv. ok is false if the function isn't defined anywhere in iv.
+func intervalFunc(name string, iv Interval) (Interval, bool) {
+	inf := math.Inf(1)
+	one := Interval{1, 1}
+
+	switch name {
+	case "sin", "cos":
+		fn := math.Sin
+		maxAt := math.Pi / 2
+		if name == "cos" {
+			fn = math.Cos
+			maxAt = 0
+		}
+
+		z := Interval{math.Min(fn(iv.Lo), fn(iv.Hi)), math.Max(fn(iv.Lo), fn(iv.Hi))}
+		z = outward(z.Lo, z.Hi, libraryUlps)
+		if containsPeriodic(iv, maxAt, 2*math.Pi) {
+			z.Hi = 1
+		}
+		if containsPeriodic(iv, maxAt+math.Pi, 2*math.Pi) {
+			z.Lo = -1
+		}
+		return clip(z, -1, 1)
+
+	case "tan":
+		// Increasing between the poles at pi/2 + k*pi
+		if containsPeriodic(iv, math.Pi/2, math.Pi) {
+			return wholeLine, true
+		}
+		return monotonic(math.Tan, iv, true), true
+
+	case "cot":
+		// Decreasing between the poles at k*pi
+		if containsPeriodic(iv, 0, math.Pi) {
+			return wholeLine, true
+		}
+		return monotonic(realFuncs["cot"], iv, false), true
+
+	case "sec", "csc", "sech", "csch", "coth":
+		// These are all just 1/something
+		z, _ := intervalFunc(reciprocalFuncs[name], iv)
+		return intervalDiv(one, z)
+
+	case "asin":
+		iv, ok := clip(iv, -1, 1)
+		return monotonic(math.Asin, iv, true), ok
+	case "acos":
+		iv, ok := clip(iv, -1, 1)
+		return monotonic(math.Acos, iv, false), ok
+	case "atan":
+		return monotonic(math.Atan, iv, true), true
+
+	case "acot":
+		// acot(0) is pi/2, which is only the limit from above, so if iv
+		// contains zero that needs adding in by hand
+		z := outward(math.Pi/2, math.Pi/2, 1)
+		if inv, ok := intervalDiv(one, iv); ok {
+			atan, _ := intervalFunc("atan", inv)
+			if iv.Lo > 0 || iv.Hi < 0 {
+				return atan, true
+			}
+			z = Interval{math.Min(z.Lo, atan.Lo), math.Max(z.Hi, atan.Hi)}
+		}
+		return z, true
+
+	case "asec", "acsc", "asech", "acsch", "acoth":
+		inv, ok := intervalDiv(one, iv)
+		if !ok {
+			return Interval{}, false
+		}
+		return intervalFunc(reciprocalInverses[name], inv)
+
+	case "sinh":
+		return monotonic(math.Sinh, iv, true), true
+	case "tanh":
+		return monotonic(math.Tanh, iv, true), true
+	case "cosh":
+		// Down to a minimum at zero then back up
+		lo, hi := math.Abs(iv.Lo), math.Abs(iv.Hi)
+		if lo > hi {
+			lo, hi = hi, lo
+		}
+		if iv.Lo <= 0 && iv.Hi >= 0 {
+			lo = 0
+		}
+		z := monotonic(math.Cosh, Interval{lo, hi}, true)
+		z.Lo = math.Max(z.Lo, 1)
+		return z, true
+
+	case "asinh":
+		return monotonic(math.Asinh, iv, true), true
+	case "acosh":
+		iv, ok := clip(iv, 1, inf)
+		z := monotonic(math.Acosh, iv, true)
+		z.Lo = math.Max(z.Lo, 0)
+		return z, ok
+	case "atanh":
+		iv, ok := clip(iv, -1, 1)
+		return monotonic(math.Atanh, iv, true), ok
+
+	case "ln", "log":
+		iv, ok := clip(iv, 0, inf)
+		return monotonic(realFuncs[name], iv, true), ok && iv.Hi > 0
+	case "sqrt":
+		iv, ok := clip(iv, 0, inf)
+		z := monotonic(math.Sqrt, iv, true)
+		z.Lo = math.Max(z.Lo, 0)
+		return z, ok
+
+	case "abs":
+		lo, hi := math.Abs(iv.Lo), math.Abs(iv.Hi)
+		if lo > hi {
+			lo, hi = hi, lo
+		}
+		if iv.Lo <= 0 && iv.Hi >= 0 {
+			lo = 0
+		}
+		return Interval{lo, hi}, true
+	case "sign":
+		return Interval{sign(iv.Lo), sign(iv.Hi)}, true
+	}
+
+	return Interval{}, false
+}
+
+// intervalMultiFunc is intervalFunc for the functions with more than one
+// argument.
+func intervalMultiFunc(name string, x []Interval) (Interval, bool) {
+	one := Interval{1, 1}
+
+	switch name {
+	case "log":
+		// ln(x)/ln(b)
+		lb, ok := intervalFunc("ln", x[0])
+		if !ok {
+			return Interval{}, false
+		}
+		la, ok := intervalFunc("ln", x[1])
+		if !ok {
+			return Interval{}, false
+		}
+		return intervalDiv(la, lb)
+
+	case "atan2":
+		y, a := x[0], x[1]
+		if y.Lo == 0 && y.Hi == 0 && a.Lo == 0 && a.Hi == 0 {
+			return Interval{}, false
+		}
+
+		// atan2 is continuous everywhere except along the negative x axis,
+		// where it jumps from pi to -pi. To the right it's atan(y/x), and
+		// above or below it's pi/2 - atan(x/y) or -pi/2 - atan(x/y).
+		var offset float64
+		var z Interval
+		switch {
+		case a.Lo > 0:
+			z, _ = intervalDiv(y, a)
+			z, _ = intervalFunc("atan", z)
+			return z, true
+		case y.Lo > 0:
+			offset = math.Pi / 2
+		case y.Hi < 0:
+			offset = -math.Pi / 2
+		default:
+			return outward(-math.Pi, math.Pi, 1), true
+		}
+		z, _ = intervalDiv(a, y)
+		z, _ = intervalFunc("atan", z)
+		return outward(offset-z.Hi, offset-z.Lo, 1), true
+
+	case "min", "max":
+		z := x[0]
+		for _, a := range x[1:] {
+			if name == "min" {
+				z = Interval{math.Min(z.Lo, a.Lo), math.Min(z.Hi, a.Hi)}
+			} else {
+				z = Interval{math.Max(z.Lo, a.Lo), math.Max(z.Hi, a.Hi)}
+			}
+		}
+		return z, true
+
+	case "root":
+		n, a := x[0], x[1]
+		if n.Lo == n.Hi && isOddInteger(n.Lo) {
+			// Odd roots are defined (and increasing) on the whole line, so
+			// negative powers are just the reciprocal
+			if n.Lo < 0 {
+				z, _ := intervalMultiFunc(name, []Interval{{-n.Hi, -n.Lo}, a})
+				return intervalDiv(one, z)
+			}
+			return monotonic(func(v float64) float64 { return realRoot(n.Lo, v) }, a, true), true
+		}
+
+		inv, ok := intervalDiv(one, n)
+		if !ok {
+			return Interval{}, false
+		}
+		z, ok := intervalPow(a, inv)
+
+		// Odd roots of negative numbers are negative
+		if k := math.Ceil(n.Lo); a.Lo < 0 && k <= n.Hi && (isOddInteger(k) || k+1 <= n.Hi) {
+			m, _ := intervalPow(Interval{math.Max(-a.Hi, 0), -a.Lo}, inv)
+			if !ok {
+				return Interval{-m.Hi, -m.Lo}, true
+			}
+			z = Interval{math.Min(z.Lo, -m.Hi), math.Max(z.Hi, -m.Lo)}
+		}
+		return z, ok
+
+	case "hypot":
+		sum := Interval{0, 0}
+		for _, a := range x {
+			sq, _ := intervalPow(a, Interval{2, 2})
+			sum = Interval{addDown(sum.Lo, sq.Lo), addUp(sum.Hi, sq.Hi)}
+		}
+		return intervalFunc("sqrt", sum)
+	}
+
+	return Interval{}, false
+}
+
+// x! = gamma(x + 1), which has its minimum on the positive reals here
+const (
+	factorialMinAt = 0.46163214496836234
+	factorialMin   = 0.8856031944108887
+)
+
+func intervalFactorial(iv Interval) Interval {
+	if iv.Lo > -1 {
+		switch {
+		case iv.Hi <= factorialMinAt:
+			return monotonic(factorial, iv, false)
+		case iv.Lo >= factorialMinAt:
+			return monotonic(factorial, iv, true)
+		}
+
+		return outward(factorialMin, math.Max(factorial(iv.Lo), factorial(iv.Hi)), libraryUlps)
+	}
+
+	// Below -1 the factorial has a pole at every negative integer, and
+	// swings between them
+	if iv.Lo == iv.Hi && factorialDefined(iv.Lo) {
+		return outward(factorial(iv.Lo), factorial(iv.Lo), libraryUlps)
+	}
+	return wholeLine
+}
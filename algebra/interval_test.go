@@ -0,0 +1,95 @@
+package algebra
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+var intervalExpressions = []string{
+	"x + y", "x - y", "x*y", "x/y", "x^y", "y^x", "x^2", "x^3", "x^-1", "x^-2", "x^0.5",
+	"x^(1/3)", "2^x", "(x - y)^2", "x!", "-x*y", "0.1x + pi",
+	"log(x, y)", "atan2(y, x)", "root(3, x)", "root(y, x)", "hypot(x, y)",
+	"min(x, y)", "max(x, 2y, -1)",
+}
+
+var intervalBoxes = [][2]Interval{
+	{{0, 0}, {0, 1}},
+	{{0, 0}, {-1, 0}},
+	{{0, 1}, {0, 0}},
+	{{-1, 1}, {-1, 1}},
+	{{0.5, 2}, {-3, -1}},
+	{{-2.5, -2}, {0, 0.5}},
+	{{1, 1}, {2, 2}},
+	{{-4, 3}, {1, 5}},
+	{{0.1, 0.3}, {0.7, 0.9}},
+}
+
+func TestEvalIntervalEncloses(t *testing.T) {
+	exps := append([]string{}, intervalExpressions...)
+	for name := range realFuncs {
+		exps = append(exps, name+"(x)", name+"(x*y)", name+"(x + y)")
+	}
+
+	for _, s := range exps {
+		e, err := Parse(s)
+		if err != nil {
+			t.Fatal(s, err)
+		}
+		for _, box := range intervalBoxes {
+			checkEnclosure(t, e, s, box)
+		}
+	}
+}
+
+// checkEnclosure evaluates e at points spread across box, including its
+// corners, and checks they're all inside EvalInterval's result.
+func checkEnclosure(t *testing.T, e *Expression, s string, box [2]Interval) {
+	t.Helper()
+
+	const steps = 8
+	z, zerr := e.EvalInterval(map[string]Interval{"x": box[0], "y": box[1]})
+	for i := 0; i <= steps; i++ {
+		for j := 0; j <= steps; j++ {
+			x := box[0].Lo + (box[0].Hi-box[0].Lo)*float64(i)/steps
+			y := box[1].Lo + (box[1].Hi-box[1].Lo)*float64(j)/steps
+			v, err := e.Eval(map[string]float64{"x": x, "y": y})
+			if err != nil || math.IsNaN(v) {
+				continue
+			}
+			if zerr != nil {
+				t.Errorf("%s on %v: %v, but it's %v at (%v, %v)", s, box, zerr, v, x, y)
+				return
+			}
+			if v < z.Lo || v > z.Hi {
+				t.Errorf("%s on %v: got %v, but it's %v at (%v, %v)", s, box, z, v, x, y)
+				return
+			}
+		}
+	}
+}
+
+func TestEvalIntervalPowZero(t *testing.T) {
+	e, err := Parse("x^y")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		y, want Interval
+	}{
+		{Interval{0, 1}, Interval{0, 1}},
+		{Interval{-1, 0}, Interval{1, 1}},
+		{Interval{0.5, 1}, Interval{0, 0}},
+		{Interval{-1, 1}, Interval{0, 1}},
+	} {
+		got, err := e.EvalInterval(map[string]Interval{"x": {0, 0}, "y": test.y})
+		if err != nil || got != test.want {
+			t.Errorf("0^%v: got %v, %v want %v", test.y, got, err, test.want)
+		}
+	}
+
+	var domain *DomainError
+	if _, err := e.EvalInterval(map[string]Interval{"x": {0, 0}, "y": {-2, -1}}); !errors.As(err, &domain) {
+		t.Errorf("0^[-2, -1]: got %v, want a domain error", err)
+	}
+}
@@ -0,0 +1,664 @@
+package algebra
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// ParseLatex parses the LaTeX that ToLatex writes, and most of what people
+// write by hand: \frac{a}{b}, \sqrt{x} and \sqrt[n]{x}, powers like
+// x^{n+1}, \left( and \right), \cdot and \times, \log_{b}, and commands for
+// the functions, \pi and the Greek letters. As in TeX, letters are single
+// variables, so xy is x*y, and a power without braces is just the next
+// character, so x^23 is x^2*3. Numbers are the exception, and can have
+// exponents like 1.5e-3, as ToLatex writes them.
+//
+// The LaTeX is turned into the same tokens Parse would get from the
+// equivalent plain expression, so it gives the same tree.
+func ParseLatex(s string) (*Expression, error) {
+	l := &latexLexer{s: s}
+	tokens, err := l.lexGroup("")
+
+	var exp *Expression
+	if err == nil {
+		exp, err = parseTokens(tokens, s, nil)
+	}
+	if err != nil {
+		if parseErr, ok := err.(*ParseError); ok {
+			parseErr.Input = s
+		}
+		return nil, err
+	}
+	return exp, nil
+}
+
+type latexLexer struct {
+	s   string
+	pos int
+}
+
+// lexGroup lexes up to close, which is "}", "]", ")" or \right, or to the
+// end of the input if close is empty. It returns the tokens in between,
+// and leaves pos after close.
+func (l *latexLexer) lexGroup(close string) ([]token, error) {
+	tokens := []token{}
+	for {
+		l.skipSpace()
+		if l.pos >= len(l.s) {
+			if close != "" {
+				return nil, &ParseError{Offset: len(l.s), Msg: "Expected '" + close + "'"}
+			}
+			return tokens, nil
+		}
+
+		if close != "" && l.closes(close) {
+			return tokens, nil
+		}
+
+		ts, err := l.lexToken()
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, ts...)
+	}
+}
+
+// closes reports whether close comes next, and if so skips over it. After
+// \right that includes its delimiter.
+func (l *latexLexer) closes(close string) bool {
+	if close != "\\right" {
+		if l.s[l.pos] != close[0] {
+			return false
+		}
+		l.pos++
+		return true
+	}
+
+	start := l.pos
+	if l.s[start] != '\\' || l.command() != "right" {
+		l.pos = start
+		return false
+	}
+	l.skipSpace()
+	if l.pos < len(l.s) {
+		if l.s[l.pos] == '\\' {
+			l.command()
+		} else {
+			l.pos++
+		}
+	}
+	return true
+}
+
+// lexToken lexes whatever comes next, which might be several tokens for
+// something like \frac{a}{b}.
+func (l *latexLexer) lexToken() ([]token, error) {
+	s, start := l.s, l.pos
+	c := s[start]
+
+	switch {
+	case isDigit(c):
+		// Numbers are read like Parse reads them, so 1e5 is a number as
+		// ToLatex writes it, rather than 1*e*5
+		end := lexNumber(s, start)
+		l.pos = end
+		return []token{{NUMBER, s[start:end], start, end}}, nil
+
+	case isLetter(c):
+		l.pos++
+		if c == 'e' || c == 'i' {
+			return []token{{CONSTANT, s[start:l.pos], start, l.pos}}, nil
+		}
+		return l.variable(start, s[start:l.pos])
+
+	case c == '\\':
+		return l.lexCommand()
+
+	case c == '{':
+		l.pos++
+		return l.wrap(start, "}")
+
+	case c == '(':
+		l.pos++
+		return l.wrap(start, ")")
+
+	case c == '[':
+		l.pos++
+		return l.wrap(start, "]")
+
+	case c == '^':
+		l.pos++
+		arg, err := l.lexArg()
+		if err != nil {
+			return nil, err
+		}
+		return append([]token{{OP_HIGH, "^", start, start + 1}}, arg...), nil
+
+	case c == ')' || c == ']' || c == '}':
+		return nil, &ParseError{Offset: start, Token: s[start : start+1], Msg: "Unmatched '" + s[start:start+1] + "'"}
+	}
+
+	if typ, ok := symbols[c]; ok {
+		l.pos++
+		return []token{{typ, s[start:l.pos], start, l.pos}}, nil
+	}
+
+	r, _ := utf8.DecodeRuneInString(s[start:])
+	return nil, &ParseError{Offset: start, Token: string(r), Msg: "Unrecognised character"}
+}
+
+// lexArg lexes the argument of a command or a power: a group in braces,
+// or otherwise the next character or command.
+func (l *latexLexer) lexArg() ([]token, error) {
+	l.skipSpace()
+	if l.pos >= len(l.s) {
+		return nil, &ParseError{Offset: len(l.s), Msg: "Expected an argument"}
+	}
+	if c := l.s[l.pos]; isDigit(c) {
+		l.pos++
+		return []token{{NUMBER, string(c), l.pos - 1, l.pos}}, nil
+	}
+	return l.lexToken()
+}
+
+// lexCommand lexes a command and any arguments it takes.
+func (l *latexLexer) lexCommand() ([]token, error) {
+	start := l.pos
+	name := l.command()
+
+	// Most commands turn into one token
+	one := func(typ uint8, value string) ([]token, error) {
+		return []token{{typ, value, start, l.pos}}, nil
+	}
+
+	switch name {
+	case ",", ";", ":", "!", " ", "quad", "qquad":
+		return nil, nil
+
+	case "cdot", "times", "ast":
+		return one(OP_MED, "*")
+
+	case "div":
+		return one(OP_MED, "/")
+
+	case "pi":
+		return one(CONSTANT, "pi")
+
+	case "vert", "lvert", "rvert", "|":
+		return one(BAR, "|")
+
+	case "frac", "dfrac", "tfrac":
+		num, err := l.lexArg()
+		if err != nil {
+			return nil, err
+		}
+		den, err := l.lexArg()
+		if err != nil {
+			return nil, err
+		}
+		return l.call("", start, num, den), nil
+
+	case "sqrt":
+		l.skipSpace()
+		if l.pos < len(l.s) && l.s[l.pos] == '[' {
+			l.pos++
+			n, err := l.lexGroup("]")
+			if err != nil {
+				return nil, err
+			}
+			x, err := l.lexArg()
+			if err != nil {
+				return nil, err
+			}
+			return l.call("root", start, n, x), nil
+		}
+
+		x, err := l.lexArg()
+		if err != nil {
+			return nil, err
+		}
+		return l.call("sqrt", start, x), nil
+
+	case "log":
+		l.skipSpace()
+		if l.pos >= len(l.s) || l.s[l.pos] != '_' {
+			return one(FUNC_PREFIX, "log")
+		}
+		l.pos++
+		base, err := l.lexArg()
+		if err != nil {
+			return nil, err
+		}
+
+		// log on its own is base 10, so that's how ToLatex writes it
+		if b := unwrap(base); len(b) == 1 && b[0].Type == NUMBER && b[0].Value == "10" {
+			return one(FUNC_PREFIX, "log")
+		}
+
+		l.skipSpace()
+		if l.pos >= len(l.s) {
+			return nil, &ParseError{Offset: l.pos, Msg: "Expected an argument for log"}
+		}
+		x, err := l.lexToken()
+		if err != nil {
+			return nil, err
+		}
+		return l.call("log", start, base, x), nil
+
+	case "left":
+		l.skipSpace()
+		if l.pos >= len(l.s) {
+			return nil, &ParseError{Offset: l.pos, Msg: "Expected a delimiter"}
+		}
+		delim := l.pos
+		if l.s[delim] == '\\' {
+			switch l.command() {
+			case "vert", "lvert", "|":
+				return l.bars(start)
+			}
+		} else {
+			l.pos++
+			switch l.s[delim] {
+			case '(', '[', '.':
+				return l.wrap(start, "\\right")
+			case '|':
+				return l.bars(start)
+			}
+		}
+		return nil, &ParseError{Offset: delim, Token: l.s[delim:l.pos], Msg: "Unknown delimiter"}
+
+	case "right":
+		return nil, &ParseError{Offset: start, Token: l.s[start:l.pos], Msg: "Unmatched '\\right'"}
+
+	case "mathrm", "operatorname":
+		text, err := l.braced()
+		if err != nil {
+			return nil, err
+		}
+		if name == "mathrm" {
+			return l.variable(start, text)
+		}
+		if fn, ok := latexFunc(text); ok {
+			return one(FUNC_PREFIX, fn)
+		}
+		return nil, &ParseError{Offset: start, Token: l.s[start:l.pos], Msg: "Unknown function: " + text}
+	}
+
+	if greekLetters[name] {
+		return l.variable(start, name)
+	}
+	if fn, ok := latexFunc(name); ok {
+		return one(FUNC_PREFIX, fn)
+	}
+	return nil, &ParseError{Offset: start, Token: l.s[start:l.pos], Msg: "Unknown command"}
+}
+
+// latexFunc finds the function a command or \operatorname stands for
+func latexFunc(name string) (string, bool) {
+	if name == "sgn" {
+		return "sign", true
+	}
+	if words[name] == FUNC_PREFIX {
+		return canonicalFunc(name), true
+	}
+	return "", false
+}
+
+// command skips over the command at pos, returning its name: either a run
+// of letters or a single other character.
+func (l *latexLexer) command() string {
+	s := l.s
+	l.pos++
+	start := l.pos
+	if l.pos < len(s) && !isLetter(s[l.pos]) {
+		l.pos++
+		return s[start:l.pos]
+	}
+	for l.pos < len(s) && isLetter(s[l.pos]) {
+		l.pos++
+	}
+	return s[start:l.pos]
+}
+
+// variable makes the token for a variable, which can have a subscript:
+// x_1 is x1, like Identifiers would read it, and x_{max} is x_max.
+func (l *latexLexer) variable(start int, name string) ([]token, error) {
+	l.skipSpace()
+	if l.pos < len(l.s) && l.s[l.pos] == '_' {
+		l.pos++
+		l.skipSpace()
+
+		var sub string
+		if l.pos < len(l.s) && l.s[l.pos] == '{' {
+			var err error
+			if sub, err = l.braced(); err != nil {
+				return nil, err
+			}
+		} else if l.pos < len(l.s) && (isLetter(l.s[l.pos]) || isDigit(l.s[l.pos])) {
+			sub = l.s[l.pos : l.pos+1]
+			l.pos++
+		}
+		if sub == "" {
+			return nil, &ParseError{Offset: l.pos, Msg: "Expected a subscript"}
+		}
+
+		if strings.Trim(sub, "0123456789") == "" {
+			name += sub
+		} else {
+			name += "_" + sub
+		}
+	}
+	return []token{{VARIABLE, name, start, l.pos}}, nil
+}
+
+// braced reads the text in braces after a command like \mathrm, which is
+// just letters and digits.
+func (l *latexLexer) braced() (string, error) {
+	l.skipSpace()
+	if l.pos >= len(l.s) || l.s[l.pos] != '{' {
+		return "", &ParseError{Offset: l.pos, Msg: "Expected '{'"}
+	}
+	end := strings.IndexByte(l.s[l.pos:], '}')
+	if end < 0 {
+		return "", &ParseError{Offset: len(l.s), Msg: "Expected '}'"}
+	}
+	text := strings.TrimSpace(l.s[l.pos+1 : l.pos+end])
+	for i := 0; i < len(text); i++ {
+		if !isLetter(text[i]) && !isDigit(text[i]) {
+			return "", &ParseError{Offset: l.pos + 1, Token: text, Msg: "Expected a name"}
+		}
+	}
+	l.pos += end + 1
+	return text, nil
+}
+
+// wrap lexes the group up to close, and puts it in brackets
+func (l *latexLexer) wrap(start int, close string) ([]token, error) {
+	inner, err := l.lexGroup(close)
+	if err != nil {
+		return nil, err
+	}
+	return l.bracket(start, inner), nil
+}
+
+// bars lexes the group up to \right, for \left| x \right|. It's made
+// into a call to abs rather than left between bars, as the \left and
+// \right say where it starts and ends, which bars don't always.
+func (l *latexLexer) bars(start int) ([]token, error) {
+	inner, err := l.lexGroup("\\right")
+	if err != nil {
+		return nil, err
+	}
+	return l.call("abs", start, inner), nil
+}
+
+// bracket puts tokens in brackets. The brackets don't take up any of the
+// input, so errors about them point at where they'd be.
+func (l *latexLexer) bracket(start int, tokens []token) []token {
+	bracketed := []token{{PAREN_OPEN, "(", start, start}}
+	bracketed = append(bracketed, tokens...)
+	return append(bracketed, token{PAREN_CLOSE, ")", l.pos, l.pos})
+}
+
+// call makes the tokens for a function of args, each of which is put in
+// brackets. With no function it's a fraction, the first arg over the
+// second, in brackets of its own.
+func (l *latexLexer) call(fn string, start int, args ...[]token) []token {
+	sep := token{COMMA, ",", start, start}
+	if fn == "" {
+		sep = token{OP_MED, "/", start, start}
+	}
+
+	tokens := []token{}
+	for i, arg := range args {
+		if i > 0 {
+			tokens = append(tokens, sep)
+		}
+		tokens = append(tokens, l.bracket(start, arg)...)
+	}
+	tokens = l.bracket(start, tokens)
+
+	if fn == "" {
+		return tokens
+	}
+	return append([]token{{FUNC_PREFIX, fn, start, start}}, tokens...)
+}
+
+// unwrap takes off any brackets that surround all of tokens
+func unwrap(tokens []token) []token {
+	for len(tokens) >= 2 && tokens[0].Type == PAREN_OPEN && tokens[len(tokens)-1].Type == PAREN_CLOSE {
+		tokens = tokens[1 : len(tokens)-1]
+	}
+	return tokens
+}
+
+func (l *latexLexer) skipSpace() {
+	for l.pos < len(l.s) && (l.s[l.pos] == ' ' || l.s[l.pos] == '\t' || l.s[l.pos] == '\n') {
+		l.pos++
+	}
+}
+
+// ToLatex writes the expression out as LaTeX, with the same brackets as
+// ToMathML. ParseLatex reads it back in.
+func (e *Expression) ToLatex() string {
+	op := e.Op
+
+	switch e.Type {
+	case NUMBER:
+		return op
+
+	case CONSTANT:
+		if op == "pi" {
+			return "\\pi"
+		}
+		return op
+
+	case VARIABLE:
+		return latexName(op)
+
+	case FUNC_PREFIX:
+		return e.latexFunc(true)
+
+	case NEGATE:
+		return "-" + e.latexOperand(e.Left, false)
+
+	case FUNC_POSTFIX:
+		return e.latexOperand(e.Left, false) + op
+
+	case OP_LOW, OP_MED, OP_HIGH:
+		switch op {
+		case "/":
+			return "\\frac{" + e.Left.ToLatex() + "}{" + e.Right.ToLatex() + "}"
+
+		case "^":
+			// The exponent goes in braces, so x^{10} isn't x^1*0. A
+			// function has to have its argument in brackets, or
+			// \sin x^{2} would look like sin(x^2).
+			base := e.latexOperand(e.Left, false)
+			if e.Left.Type == FUNC_PREFIX {
+				base = e.Left.latexFunc(false)
+			}
+			return base + "^{" + e.Right.ToLatex() + "}"
+
+		case "*":
+			left, right := e.latexOperand(e.Left, false), e.latexOperand(e.Right, true)
+			return left + latexTimes(e.Left, left, right) + right
+		}
+		return e.latexOperand(e.Left, false) + " " + op + " " + e.latexOperand(e.Right, true)
+
+	case EQUALS:
+		return e.latexOperand(e.Left, false) + " = " + e.latexOperand(e.Right, true)
+
+	case COMMA:
+		return e.Left.ToLatex() + ", " + e.Right.ToLatex()
+	}
+
+	return "\\text{" + op + "}"
+}
+
+// latexOperand writes out an operand of e, in brackets if it needs them
+func (e *Expression) latexOperand(operand *Expression, right bool) string {
+	if bracketed(e, operand, right) {
+		return latexBrackets(operand.ToLatex())
+	}
+	return operand.ToLatex()
+}
+
+func latexBrackets(s string) string {
+	return "\\left(" + s + "\\right)"
+}
+
+// latexTimes gives what goes between two things being multiplied, once
+// they've been written out as left and right. That's usually nothing, but
+// it's a \cdot if they'd look like they were something else: 2 3 would
+// look like 23, 2 \frac{1}{3} like two and a third, and \sin x y like
+// sin(xy). Commands need a space after them to keep them apart from any
+// letters.
+func latexTimes(l *Expression, left, right string) string {
+	last, first := left[len(left)-1], right[0]
+	switch {
+	case isDigit(first), isDigit(last) && strings.HasPrefix(right, "\\frac"), endsWithBareFunc(l):
+		return " \\cdot "
+	case isLetter(last) && (isLetter(first) || first == '\\'):
+		return " "
+	}
+	return ""
+}
+
+// endsWithBareFunc reports whether e, as ToLatex writes it, ends with a
+// function without brackets round its argument, like \sin x
+func endsWithBareFunc(e *Expression) bool {
+	switch e.Type {
+	case FUNC_PREFIX:
+		return e.bareFunc()
+	case NEGATE:
+		return !bracketed(e, e.Left, false) && endsWithBareFunc(e.Left)
+	case OP_MED:
+		return e.Op == "*" && !bracketed(e, e.Right, true) && endsWithBareFunc(e.Right)
+	}
+	return false
+}
+
+// bareFunc reports whether the function e can be written without
+// brackets round its argument, which is fine for a single value
+func (e *Expression) bareFunc() bool {
+	switch canonicalFunc(e.Op) {
+	case "sqrt", "root", "abs":
+		return false
+	}
+	return e.Left.Type != COMMA && !bracketed(e, e.Left, false) && words[e.Op] == FUNC_PREFIX
+}
+
+// latexFunc writes out the function e. If bare is false, its argument goes
+// in brackets even if it doesn't need them.
+func (e *Expression) latexFunc(bare bool) string {
+	args := e.Args()
+	name := latexFuncName(e.Op)
+
+	switch canonicalFunc(e.Op) {
+	case "sqrt":
+		return "\\sqrt{" + args[0].ToLatex() + "}"
+
+	case "root":
+		return "\\sqrt[" + args[0].ToLatex() + "]{" + args[1].ToLatex() + "}"
+
+	case "abs":
+		return "\\left|" + args[0].ToLatex() + "\\right|"
+
+	case "log":
+		// log on its own is base 10
+		base := "10"
+		if len(args) == 2 {
+			base = args[0].ToLatex()
+			args = args[1:]
+		}
+		name = "\\log_{" + base + "}"
+	}
+
+	if bare && len(args) == 1 && e.bareFunc() {
+		return name + " " + args[0].ToLatex()
+	}
+
+	list := make([]string, len(args))
+	for i, arg := range args {
+		list[i] = arg.ToLatex()
+	}
+	return name + latexBrackets(strings.Join(list, ", "))
+}
+
+// latexCommands are the functions LaTeX has commands for. The rest are
+// written with \operatorname.
+var latexCommands = map[string]string{
+	"sin":  "\\sin",
+	"cos":  "\\cos",
+	"tan":  "\\tan",
+	"sec":  "\\sec",
+	"csc":  "\\csc",
+	"cot":  "\\cot",
+	"sinh": "\\sinh",
+	"cosh": "\\cosh",
+	"tanh": "\\tanh",
+	"coth": "\\coth",
+	"asin": "\\arcsin",
+	"acos": "\\arccos",
+	"atan": "\\arctan",
+	"ln":   "\\ln",
+	"min":  "\\min",
+	"max":  "\\max",
+}
+
+// latexFuncName gives the command for a function. Functions defined in a
+// Context are named like variables.
+func latexFuncName(op string) string {
+	if cmd, ok := latexCommands[canonicalFunc(op)]; ok {
+		return cmd
+	}
+	if words[op] != FUNC_PREFIX {
+		return latexName(op)
+	}
+	return "\\operatorname{" + funcName(op) + "}"
+}
+
+var greekLetters = map[string]bool{
+	"alpha": true, "beta": true, "gamma": true, "delta": true, "epsilon": true,
+	"zeta": true, "eta": true, "theta": true, "iota": true, "kappa": true,
+	"lambda": true, "mu": true, "nu": true, "xi": true, "rho": true,
+	"sigma": true, "tau": true, "upsilon": true, "phi": true, "chi": true,
+	"psi": true, "omega": true,
+
+	"Gamma": true, "Delta": true, "Theta": true, "Lambda": true, "Xi": true,
+	"Pi": true, "Sigma": true, "Upsilon": true, "Phi": true, "Psi": true,
+	"Omega": true,
+}
+
+// splitName splits a variable name into the letter and its subscript, which
+// is either after an _ or the digits on the end: x_max, x1
+func splitName(name string) (base, sub string) {
+	if i := strings.IndexByte(name, '_'); i > 0 {
+		return name[:i], name[i+1:]
+	}
+	if trimmed := strings.TrimRight(name, "0123456789"); trimmed != "" && trimmed != name {
+		return trimmed, name[len(trimmed):]
+	}
+	return name, ""
+}
+
+// latexName writes out a variable name. Any subscript (after an _, or
+// digits on the end) is lowered, Greek letters' names become the letters,
+// and other multi-letter names are set upright so they don't look like a
+// product.
+func latexName(name string) string {
+	base, sub := splitName(name)
+
+	switch {
+	case greekLetters[base]:
+		base = "\\" + base
+	case len(base) > 1:
+		base = "\\mathrm{" + base + "}"
+	}
+
+	if sub != "" {
+		return base + "_{" + sub + "}"
+	}
+	return base
+}
@@ -0,0 +1,157 @@
+package algebra
+
+import "testing"
+
+// roundTripCorpus is a set of expressions that should come back as the same
+// tree after being written out and read back in again
+var roundTripCorpus = []string{
+	"1", "3.5", "1e5", "2.5e-3", "1e+5", "x", "pi", "e", "i", "θ", "Θ",
+	"x + 1", "x - y - z", "x - (y - z)", "a + -b", "a - -b", "2x", "x*2", "2*3", "x y z",
+	"a/b", "a/(b/c)", "(a/b)/c", "2/3 x", "2*(1/3)", "x^2", "a^b^c", "(a^b)^c", "x^-1", "e^(i pi)",
+	"-x", "--x", "-x^2", "(-x)^2", "-(x+1)", "x!", "(x+1)!", "(sin x)!", "x = 2", "x = -1", "a = b + c",
+	"sin x", "sin(x+1)", "sin(x)^2", "sin(x) y", "sin cos x", "sin(-x)", "cos(x)/2", "tan x^2",
+	"cosec x", "sec x", "cot x", "asin x", "acos x", "atan x", "asec x", "acosec x", "acot x",
+	"sinh x", "cosh x", "tanh x", "sech x", "cosech x", "coth x",
+	"asinh x", "acosh x", "atanh x", "asech x", "acosech x", "acoth x",
+	"ln x", "ln(x)^2", "log(2, x)", "log(x, y^2)", "sqrt(x)", "sqrt(x^2 + y^2)", "root(3, x)", "root(n, x+1)",
+	"|x|", "|x - |y||", "sign(x)", "atan2(y, x)", "min(a, b)", "max(a, b, c)", "hypot(a, b)",
+	"(x+1)(x-1)", "x^2 + 2x + 1", "e^(-x^2/2)/sqrt(2 pi)", "3sin(2x)cos(x)", "2^(1/2)", "x^(y+1)",
+}
+
+// sameTree reports whether a and b are the same tree, counting functions as
+// the same if they're different names for the same thing
+func sameTree(a, b *Expression) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	opA, opB := a.Op, b.Op
+	if a.Type == FUNC_PREFIX {
+		opA, opB = canonicalFunc(opA), canonicalFunc(opB)
+	}
+	return opA == opB && a.Type == b.Type && sameTree(a.Left, b.Left) && sameTree(a.Right, b.Right)
+}
+
+func TestToLatex(t *testing.T) {
+	for in, want := range map[string]string{
+		// Values
+		"3.5":   `3.5`,
+		"pi":    `\pi`,
+		"e":     `e`,
+		"x":     `x`,
+		"θ":     `\theta`,
+		"Θ^2":   `\Theta^{2}`,
+		"2 pi":  `2\pi`,
+		"pi r":  `\pi r`,
+		"x y z": `x y z`,
+
+		// Operators
+		"x^2 + 2x + 1": `x^{2} + 2x + 1`,
+		"a - (b - c)":  `a - \left(b - c\right)`,
+		"a - -b":       `a - \left(-b\right)`,
+		"(a^b)^c":      `\left(a^{b}\right)^{c}`,
+		"a^b^c":        `a^{b^{c}}`,
+		"x^10":         `x^{10}`,
+		"e^(i pi)":     `e^{i \pi}`,
+		"(x+1)(x-1)":   `\left(x + 1\right)\left(x - 1\right)`,
+		"2/3 x":        `\frac{2}{3}x`,
+		"a/(b/c)":      `\frac{a}{\frac{b}{c}}`,
+
+		// Juxtaposition that would be ambiguous
+		"2*3":       `2 \cdot 3`,
+		"2*3*4":     `2 \cdot 3 \cdot 4`,
+		"x*2":       `x \cdot 2`,
+		"2 * (1/3)": `2 \cdot \frac{1}{3}`,
+		"sin(x) y":  `\sin x \cdot y`,
+
+		// Negation and factorials
+		"-x":       `-x`,
+		"-a*b":     `-a b`,
+		"-(x+1)":   `-\left(x + 1\right)`,
+		"(-x)^2":   `\left(-x\right)^{2}`,
+		"x!":       `x!`,
+		"(x+1)!":   `\left(x + 1\right)!`,
+		"(sin x)!": `\left(\sin x\right)!`,
+
+		// Equations
+		"x = 2":     `x = 2`,
+		"a = b + c": `a = b + c`,
+		"x = -1":    `x = -1`,
+		"-1 = x":    `-1 = x`,
+
+		// Functions
+		"sin(x+1)":       `\sin\left(x + 1\right)`,
+		"sin(-x)":        `\sin\left(-x\right)`,
+		"sin(x)^2":       `\sin\left(x\right)^{2}`,
+		"sin cos x":      `\sin \cos x`,
+		"cosec x":        `\csc x`,
+		"coth x":         `\coth x`,
+		"sech x":         `\operatorname{sech} x`,
+		"cosech x":       `\operatorname{csch} x`,
+		"csch x":         `\operatorname{csch} x`,
+		"asin x":         `\arcsin x`,
+		"asec x":         `\operatorname{arcsec} x`,
+		"acosec x":       `\operatorname{arccsc} x`,
+		"acot x":         `\operatorname{arccot} x`,
+		"asinh x":        `\operatorname{arsinh} x`,
+		"acosh x":        `\operatorname{arcosh} x`,
+		"atanh x":        `\operatorname{artanh} x`,
+		"asech x":        `\operatorname{arsech} x`,
+		"acsch x":        `\operatorname{arcsch} x`,
+		"arcosech x":     `\operatorname{arcsch} x`,
+		"acoth x":        `\operatorname{arcoth} x`,
+		"sqrt(x)":        `\sqrt{x}`,
+		"root(3, x)":     `\sqrt[3]{x}`,
+		"|x|":            `\left|x\right|`,
+		"sign(x)":        `\operatorname{sgn} x`,
+		"log(x)":         `\log_{10} x`,
+		"log(2, x+1)":    `\log_{2}\left(x + 1\right)`,
+		"atan2(y, x)":    `\operatorname{atan2}\left(y, x\right)`,
+		"min(a, b)":      `\min\left(a, b\right)`,
+		"hypot(a, b, c)": `\operatorname{hypot}\left(a, b, c\right)`,
+	} {
+		e, err := Parse(in)
+		if err != nil {
+			t.Fatal(in, err)
+		}
+		if got := e.ToLatex(); got != want {
+			t.Errorf("%s: got %s want %s", in, got, want)
+		}
+	}
+}
+
+func TestToLatexNodes(t *testing.T) {
+	x, y := &Expression{"x", VARIABLE, nil, nil}, &Expression{"y", VARIABLE, nil, nil}
+	for _, test := range []struct {
+		e    *Expression
+		want string
+	}{
+		{&Expression{"x_1", VARIABLE, nil, nil}, `x_{1}`},
+		{&Expression{"x1", VARIABLE, nil, nil}, `x_{1}`},
+		{&Expression{"v_max", VARIABLE, nil, nil}, `v_{max}`},
+		{&Expression{"theta_0", VARIABLE, nil, nil}, `\theta_{0}`},
+		{&Expression{"speed", VARIABLE, nil, nil}, `\mathrm{speed}`},
+		{&Expression{",", COMMA, x, y}, `x, y`},
+		{&Expression{"f", FUNC_PREFIX, x, nil}, `f\left(x\right)`},
+		{&Expression{"?", 99, nil, nil}, `\text{?}`},
+	} {
+		if got := test.e.ToLatex(); got != test.want {
+			t.Errorf("%s: got %s want %s", test.e.Format(FormatOptions{}), got, test.want)
+		}
+	}
+}
+
+func TestLatexRoundTrip(t *testing.T) {
+	for _, in := range roundTripCorpus {
+		e, err := Parse(in)
+		if err != nil {
+			t.Fatal(in, err)
+		}
+		latex := e.ToLatex()
+		back, err := ParseLatex(latex)
+		if err != nil {
+			t.Errorf("%s: %s: %v", in, latex, err)
+		} else if !sameTree(e, back) {
+			t.Errorf("%s: %s reads back as %s", in, latex, back.Format(FormatOptions{}))
+		}
+	}
+}
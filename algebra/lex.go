@@ -0,0 +1,408 @@
+package algebra
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// words are the multi-letter tokens: the prefix functions and constants.
+// Letters that don't start one of these are single letter variables. Only
+// atan2 has a digit in it.
+var words = map[string]uint8{
+	"ln":   FUNC_PREFIX,
+	"log":  FUNC_PREFIX,
+	"sqrt": FUNC_PREFIX,
+	"abs":  FUNC_PREFIX,
+	"sign": FUNC_PREFIX,
+
+	"atan2": FUNC_PREFIX,
+	"min":   FUNC_PREFIX,
+	"max":   FUNC_PREFIX,
+	"root":  FUNC_PREFIX,
+	"hypot": FUNC_PREFIX,
+
+	"e":  CONSTANT,
+	"i":  CONSTANT,
+	"pi": CONSTANT,
+}
+
+// longestWord is the length of the longest key in words
+var longestWord int
+
+func init() {
+	// All the trig functions, with any inverse prefix and/or hyperbolic
+	// suffix
+	for _, prefix := range []string{"", "a", "ar", "arc"} {
+		for _, fn := range []string{"sin", "cos", "tan", "sec", "csc", "cot", "cosec"} {
+			words[prefix+fn] = FUNC_PREFIX
+			words[prefix+fn+"h"] = FUNC_PREFIX
+		}
+	}
+
+	for word := range words {
+		if len(word) > longestWord {
+			longestWord = len(word)
+		}
+	}
+}
+
+var symbols = map[byte]uint8{
+	'(': PAREN_OPEN,
+	')': PAREN_CLOSE,
+	'!': FUNC_POSTFIX,
+	'+': OP_LOW,
+	'-': OP_LOW,
+	'*': OP_MED,
+	'/': OP_MED,
+	'^': OP_HIGH,
+	'=': EQUALS,
+	',': COMMA,
+	'|': BAR,
+}
+
+// unicodeSymbols are the characters that turn up when formulas are pasted
+// from documents, and the tokens they stand for
+var unicodeSymbols = map[rune]struct {
+	typ   uint8
+	value string
+}{
+	'×': {OP_MED, "*"},
+	'·': {OP_MED, "*"},
+	'⋅': {OP_MED, "*"},
+	'∗': {OP_MED, "*"},
+	'÷': {OP_MED, "/"},
+	'∕': {OP_MED, "/"},
+	'−': {OP_LOW, "-"},
+	'√': {FUNC_PREFIX, "sqrt"},
+	'π': {CONSTANT, "pi"},
+}
+
+// greekRunes gives the names of the Greek letters, which are variables
+// named the same as when they're typed out with Identifiers.
+var greekRunes = map[rune]string{
+	'α': "alpha", 'β': "beta", 'γ': "gamma", 'δ': "delta", 'ε': "epsilon",
+	'ζ': "zeta", 'η': "eta", 'θ': "theta", 'ι': "iota", 'κ': "kappa",
+	'λ': "lambda", 'μ': "mu", 'ν': "nu", 'ξ': "xi", 'ρ': "rho",
+	'σ': "sigma", 'τ': "tau", 'υ': "upsilon", 'φ': "phi", 'χ': "chi",
+	'ψ': "psi", 'ω': "omega",
+
+	'Γ': "Gamma", 'Δ': "Delta", 'Θ': "Theta", 'Λ': "Lambda", 'Ξ': "Xi",
+	'Π': "Pi", 'Σ': "Sigma", 'Υ': "Upsilon", 'Φ': "Phi", 'Ψ': "Psi",
+	'Ω': "Omega",
+}
+
+// superscripts are the characters that can make up a superscript exponent,
+// like the ² in x² or the ⁻¹ in x⁻¹
+var superscripts = map[rune]byte{
+	'⁰': '0', '¹': '1', '²': '2', '³': '3', '⁴': '4',
+	'⁵': '5', '⁶': '6', '⁷': '7', '⁸': '8', '⁹': '9',
+	'⁺': '+', '⁻': '-',
+}
+
+// A scope holds the words a Context adds to the built in ones: its
+// functions and named expressions, and the parameters of a function while
+// its body is being parsed. They have to be written exactly as they were
+// defined.
+type scope struct {
+	words   map[string]uint8
+	arities map[string]int
+	longest int
+}
+
+func (sc *scope) add(name string, typ uint8) {
+	sc.words[name] = typ
+	if len(name) > sc.longest {
+		sc.longest = len(name)
+	}
+}
+
+type lexer struct {
+	s     string
+	opts  ParseOptions
+	scope *scope
+
+	// declared holds opts.Symbols, and longest is the length of the
+	// longest word that can be matched, including the symbols and the
+	// scope's words
+	declared map[string]bool
+	longest  int
+}
+
+// tokenize splits s into tokens in a single pass from left to right. At
+// each point it takes the longest token that matches, so 1e5 is one number
+// and cosec is one function rather than co*sec. sc can be nil. Unicode
+// symbols are turned into the tokens they stand for, but keep their
+// positions in s.
+func tokenize(s string, opts ParseOptions, sc *scope) ([]token, error) {
+	tokens := make([]token, 0, len(s)/2)
+
+	l := &lexer{
+		s:        s,
+		opts:     opts,
+		scope:    sc,
+		declared: make(map[string]bool, len(opts.Symbols)),
+		longest:  longestWord,
+	}
+	for _, name := range opts.Symbols {
+		l.declared[name] = true
+		if len(name) > l.longest {
+			l.longest = len(name)
+		}
+	}
+	if sc != nil && sc.longest > l.longest {
+		l.longest = sc.longest
+	}
+
+	for pos := 0; pos < len(s); {
+		c := s[pos]
+
+		switch {
+		case isDigit(c):
+			end := lexNumber(s, pos)
+			tokens = append(tokens, token{NUMBER, s[pos:end], pos, end})
+			pos = end
+
+		case isLetter(c) && opts.Identifiers:
+			end, typ := l.lexIdentifier(pos)
+			tokens = append(tokens, token{typ, l.value(s[pos:end], typ), pos, end})
+			pos = end
+
+		case isLetter(c):
+			end := l.lexWord(pos)
+			typ, ok := l.lookupWord(s[pos:end])
+			if !ok {
+				typ = VARIABLE
+			}
+			tokens = append(tokens, token{typ, l.value(s[pos:end], typ), pos, end})
+			pos = end
+
+		case c == '*' && pos+1 < len(s) && s[pos+1] == '*':
+			// x**2 is another way of writing x^2
+			tokens = append(tokens, token{OP_HIGH, "^", pos, pos + 2})
+			pos += 2
+
+		default:
+			if typ, ok := symbols[c]; ok {
+				tokens = append(tokens, token{typ, s[pos : pos+1], pos, pos + 1})
+				pos++
+				continue
+			}
+
+			r, size := utf8.DecodeRuneInString(s[pos:])
+			if sym, ok := unicodeSymbols[r]; ok {
+				tokens = append(tokens, token{sym.typ, sym.value, pos, pos + size})
+				pos += size
+				continue
+			}
+			if name, ok := greekRunes[r]; ok {
+				end := pos + size
+				if opts.Identifiers {
+					end = lexSubscript(s, end)
+				}
+				tokens = append(tokens, token{VARIABLE, name + s[pos+size:end], pos, end})
+				pos = end
+				continue
+			}
+			if _, ok := superscripts[r]; ok {
+				tokens, pos = lexSuperscript(s, pos, tokens)
+				continue
+			}
+
+			if !unicode.IsSpace(r) && !opts.Lenient {
+				return nil, &ParseError{Offset: pos, Token: string(r), Msg: "Unrecognised character"}
+			}
+			pos += size
+		}
+	}
+
+	return tokens, nil
+}
+
+// lookupWord finds the type of a function or constant name, or one of the
+// scope's words. Built in function names can be in any case, and so can
+// constants unless CaseSensitive is set, in which case E or I are left to
+// be variables.
+func (l *lexer) lookupWord(word string) (uint8, bool) {
+	if l.scope != nil {
+		if typ, ok := l.scope.words[word]; ok {
+			return typ, true
+		}
+	}
+
+	lower := strings.ToLower(word)
+	typ, ok := words[lower]
+	if ok && typ == CONSTANT && l.opts.CaseSensitive && word != lower {
+		return 0, false
+	}
+	return typ, ok
+}
+
+// value gives the value of the token for a word: built in functions and
+// constants are always lower case, and everything else stays as written.
+func (l *lexer) value(word string, typ uint8) string {
+	if typ == VARIABLE {
+		return word
+	}
+	if l.scope != nil {
+		if _, ok := l.scope.words[word]; ok {
+			return word
+		}
+	}
+	return strings.ToLower(word)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isIdentChar(c byte) bool {
+	return isLetter(c) || isDigit(c) || c == '_'
+}
+
+// lexNumber returns the end of the number starting at pos, which looks like
+// 12, 1.2, 1e5, 1e+5 or 1.2e-5. The fraction and exponent only count if they
+// have digits in them, so 2e is 2*e rather than a broken number.
+func lexNumber(s string, pos int) int {
+	digits := func(i int) int {
+		for i < len(s) && isDigit(s[i]) {
+			i++
+		}
+		return i
+	}
+
+	end := digits(pos)
+
+	if end+1 < len(s) && s[end] == '.' && isDigit(s[end+1]) {
+		end = digits(end + 1)
+	}
+
+	if end < len(s) && (s[end] == 'e' || s[end] == 'E') {
+		i := end + 1
+		if i < len(s) && (s[i] == '-' || s[i] == '+') {
+			i++
+		}
+		if i < len(s) && isDigit(s[i]) {
+			end = digits(i)
+		}
+	}
+
+	return end
+}
+
+// lexSuperscript adds the tokens for the superscript starting at pos, which
+// is a sign and/or some digits, so x² is read as x^2 and x⁻¹ as x^-1. It
+// returns where the superscript ends. The ^ covers the whole superscript,
+// and the sign and number just their own parts of it.
+func lexSuperscript(s string, pos int, tokens []token) ([]token, int) {
+	next := func(i int) (byte, int) {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		return superscripts[r], size
+	}
+
+	caret := len(tokens)
+	tokens = append(tokens, token{OP_HIGH, "^", pos, pos})
+
+	if c, size := next(pos); c == '+' || c == '-' {
+		tokens = append(tokens, token{OP_LOW, string(c), pos, pos + size})
+		pos += size
+	}
+
+	start := pos
+	digits := []byte{}
+	for pos < len(s) {
+		c, size := next(pos)
+		if !isDigit(c) {
+			break
+		}
+		digits = append(digits, c)
+		pos += size
+	}
+	if len(digits) > 0 {
+		tokens = append(tokens, token{NUMBER, string(digits), start, pos})
+	}
+
+	tokens[caret].End = pos
+	return tokens, pos
+}
+
+// lexWord returns the end of the longest word starting at pos, or just the
+// first letter if none of them match. A word can't end part way through a
+// number, so atan20 is atan(20) rather than atan2 followed by 0.
+func (l *lexer) lexWord(pos int) int {
+	s := l.s
+	end := pos
+	for end < len(s) && end-pos < l.longest && (isLetter(s[end]) || isDigit(s[end])) {
+		end++
+	}
+
+	for ; end > pos+1; end-- {
+		if end < len(s) && isDigit(s[end-1]) && isDigit(s[end]) {
+			continue
+		}
+		if _, ok := l.lookupWord(s[pos:end]); ok {
+			break
+		}
+	}
+	return end
+}
+
+// lexIdentifier returns the end and type of the name starting at pos, when
+// parsing with Identifiers. With no symbols declared, that's the whole run
+// of letters, digits and underscores. Otherwise the longest declared
+// symbol, function or constant at pos is taken, or failing that a single
+// letter, and a variable gets any subscript that follows it.
+func (l *lexer) lexIdentifier(pos int) (int, uint8) {
+	s := l.s
+	end := pos
+	for end < len(s) && isIdentChar(s[end]) {
+		end++
+	}
+
+	if typ, ok := l.lookupWord(s[pos:end]); ok {
+		return end, typ
+	}
+	if len(l.declared) == 0 {
+		return end, VARIABLE
+	}
+
+	if end-pos > l.longest {
+		end = pos + l.longest
+	}
+	for ; end > pos+1; end-- {
+		if l.declared[s[pos:end]] {
+			return lexSubscript(s, end), VARIABLE
+		}
+		if typ, ok := l.lookupWord(s[pos:end]); ok {
+			return end, typ
+		}
+	}
+
+	end = lexSubscript(s, pos+1)
+	if typ, ok := l.lookupWord(s[pos : pos+1]); ok && end == pos+1 {
+		return end, typ
+	}
+	return end, VARIABLE
+}
+
+// lexSubscript returns the end of the subscript starting at pos, which is
+// either some digits or an _ and the letters and digits after it.
+func lexSubscript(s string, pos int) int {
+	end := pos
+	if end+1 < len(s) && s[end] == '_' && isIdentChar(s[end+1]) {
+		end++
+		for end < len(s) && (isLetter(s[end]) || isDigit(s[end])) {
+			end++
+		}
+		return end
+	}
+
+	for end < len(s) && isDigit(s[end]) {
+		end++
+	}
+	return end
+}
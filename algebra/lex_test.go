@@ -0,0 +1,134 @@
+package algebra
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestLexNumber(t *testing.T) {
+	for s, want := range map[string]int{
+		"12":     2,
+		"1.2":    3,
+		"1.":     1,
+		"1e5":    3,
+		"1E5":    3,
+		"1e-5":   4,
+		"1e+5":   4,
+		"1.2e-5": 6,
+		"2e":     1,
+		"2e+":    1,
+		"2e-x":   1,
+		"2ex":    1,
+	} {
+		if got := lexNumber(s, 0); got != want {
+			t.Errorf("%s: got %d want %d", s, got, want)
+		}
+	}
+}
+
+// A function name can't end between two digits, so atan20 isn't atan2(0)
+func TestFunctionNameBeforeNumber(t *testing.T) {
+	for in, want := range map[string]string{
+		"atan20":      "atan(20)",
+		"atan2(y, x)": "atan2(y, x)",
+		"sin2x":       "sin(2)*x",
+	} {
+		e, err := Parse(in)
+		if err != nil {
+			t.Errorf("%s: %v", in, err)
+		} else if got := e.Format(FormatOptions{}); got != want {
+			t.Errorf("%s: got %s want %s", in, got, want)
+		}
+	}
+}
+
+// The Unicode operators come out as their ASCII equivalents, but with
+// positions that are byte offsets into the original string
+func TestTokenizeUnicode(t *testing.T) {
+	for in, want := range map[string][]token{
+		"2×3": {{NUMBER, "2", 0, 1}, {OP_MED, "*", 1, 3}, {NUMBER, "3", 3, 4}},
+		"6÷2": {{NUMBER, "6", 0, 1}, {OP_MED, "/", 1, 3}, {NUMBER, "2", 3, 4}},
+		"2−1": {{NUMBER, "2", 0, 1}, {OP_LOW, "-", 1, 4}, {NUMBER, "1", 4, 5}},
+		"√x":  {{FUNC_PREFIX, "sqrt", 0, 3}, {VARIABLE, "x", 3, 4}},
+		"x²":  {{VARIABLE, "x", 0, 1}, {OP_HIGH, "^", 1, 3}, {NUMBER, "2", 1, 3}},
+		"x⁴":  {{VARIABLE, "x", 0, 1}, {OP_HIGH, "^", 1, 4}, {NUMBER, "4", 1, 4}},
+		"x⁻¹²": {
+			{VARIABLE, "x", 0, 1}, {OP_HIGH, "^", 1, 8}, {OP_LOW, "-", 1, 4}, {NUMBER, "12", 4, 8},
+		},
+		"θ×x": {{VARIABLE, "theta", 0, 2}, {OP_MED, "*", 2, 4}, {VARIABLE, "x", 4, 5}},
+		"2 × √x³": {
+			{NUMBER, "2", 0, 1}, {OP_MED, "*", 2, 4}, {FUNC_PREFIX, "sqrt", 5, 8},
+			{VARIABLE, "x", 8, 9}, {OP_HIGH, "^", 9, 11}, {NUMBER, "3", 9, 11},
+		},
+	} {
+		got, err := tokenize(in, ParseOptions{}, nil)
+		if err != nil {
+			t.Errorf("%s: %v", in, err)
+		} else if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %v want %v", in, got, want)
+		}
+	}
+}
+
+func TestParseUnicode(t *testing.T) {
+	for in, want := range map[string]string{
+		"2×3":         "2*3",
+		"a·b⋅c∗d":     "a*b*c*d",
+		"6÷2∕3":       "6/2/3",
+		"2−1":         "2-1",
+		"−x":          "-x",
+		"√x":          "sqrt(x)",
+		"√(x + 1)":    "sqrt(x + 1)",
+		"2√x":         "2 sqrt(x)",
+		"√x²":         "sqrt(x)^2",
+		"x²":          "x^2",
+		"x¹⁰":         "x^10",
+		"x⁻¹":         "x^-1",
+		"x⁺³":         "x^+3",
+		"(x + 1)²":    "(x + 1)^2",
+		"2x³ − 4x²":   "2x^3 - 4x^2",
+		"θ² × π":      "θ^2 * pi",
+		"e^(−x²÷2)":   "e^(-x^2/2)",
+		"x² + y² = 1": "x^2 + y^2 = 1",
+	} {
+		got, err := Parse(in)
+		if err != nil {
+			t.Errorf("%s: %v", in, err)
+			continue
+		}
+		w, err := Parse(want)
+		if err != nil {
+			t.Fatal(want, err)
+		}
+		if !sameTree(got, w) {
+			t.Errorf("%s: got %s want %s", in, got.Format(FormatOptions{}), w.Format(FormatOptions{}))
+		}
+	}
+}
+
+// The tokenizer should take time in proportion to the length of its input,
+// so the ns/op of each size should go up by about ten times, and the MB/s
+// stay about the same.
+func BenchmarkTokenize(b *testing.B) {
+	inputs := map[string]string{
+		"mixed":   "sin(x) + 2.5e3*cosec(y) - sqrt(theta)/3! + ",
+		"letters": "abcdefghpqrstuvw",
+		"numbers": "1234567890.5e+3 ",
+	}
+
+	for name, unit := range inputs {
+		for _, n := range []int{10, 100, 1000, 10000} {
+			s := strings.Repeat(unit, n)
+			b.Run(name+"/"+strconv.Itoa(len(s)), func(b *testing.B) {
+				b.SetBytes(int64(len(s)))
+				for i := 0; i < b.N; i++ {
+					if _, err := tokenize(s, ParseOptions{}, nil); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
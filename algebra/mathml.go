@@ -0,0 +1,611 @@
+package algebra
+
+import (
+	"encoding/xml"
+	"html"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const mathmlNamespace = "http://www.w3.org/1998/Math/MathML"
+
+// ToMathML writes the expression out as presentation MathML, which says
+// how it should look. It's bracketed the same way as ToLatex.
+func (e *Expression) ToMathML() string {
+	return `<math xmlns="` + mathmlNamespace + `">` + e.mathML() + `</math>`
+}
+
+func (e *Expression) mathML() string {
+	op := e.Op
+
+	switch e.Type {
+	case NUMBER:
+		return "<mn>" + op + "</mn>"
+
+	case CONSTANT:
+		if op == "pi" {
+			return "<mi>π</mi>"
+		}
+		return "<mi>" + op + "</mi>"
+
+	case VARIABLE:
+		return mathmlName(op)
+
+	case FUNC_PREFIX:
+		return e.mathmlFunc(true)
+
+	case NEGATE:
+		return "<mrow><mo>−</mo>" + e.mathmlOperand(e.Left, false) + "</mrow>"
+
+	case FUNC_POSTFIX:
+		return "<mrow>" + e.mathmlOperand(e.Left, false) + "<mo>" + html.EscapeString(op) + "</mo></mrow>"
+
+	case OP_LOW, OP_MED, OP_HIGH:
+		switch op {
+		case "/":
+			return "<mfrac>" + e.Left.mathML() + e.Right.mathML() + "</mfrac>"
+
+		case "^":
+			// A function has to have its argument in brackets, or sin x^2
+			// would look like sin(x^2)
+			base := e.mathmlOperand(e.Left, false)
+			if e.Left.Type == FUNC_PREFIX {
+				base = e.Left.mathmlFunc(false)
+			}
+			return "<msup>" + base + "<mrow>" + e.Right.mathML() + "</mrow></msup>"
+
+		case "*":
+			// Multiplication is usually invisible, but not where ToLatex
+			// writes a \cdot
+			op = "&#x2062;"
+			if e.visibleTimes() {
+				op = "⋅"
+			}
+
+		case "-":
+			op = "−"
+		}
+		return "<mrow>" + e.mathmlOperand(e.Left, false) + "<mo>" + op + "</mo>" + e.mathmlOperand(e.Right, true) + "</mrow>"
+
+	case EQUALS:
+		return "<mrow>" + e.mathmlOperand(e.Left, false) + "<mo>=</mo>" + e.mathmlOperand(e.Right, true) + "</mrow>"
+
+	case COMMA:
+		return e.Left.mathML() + "<mo>,</mo>" + e.Right.mathML()
+	}
+
+	return "<merror><mtext>" + html.EscapeString(op) + "</mtext></merror>"
+}
+
+// mathmlFunc writes out the function e. If bare is false, its argument
+// goes in brackets even if it doesn't need them.
+func (e *Expression) mathmlFunc(bare bool) string {
+	args := e.Args()
+	name := "<mi>" + html.EscapeString(funcName(e.Op)) + "</mi>"
+
+	switch canonicalFunc(e.Op) {
+	case "sqrt":
+		return "<msqrt>" + e.Left.mathML() + "</msqrt>"
+
+	case "root":
+		return "<mroot>" + args[1].mathML() + args[0].mathML() + "</mroot>"
+
+	case "abs":
+		return "<mrow><mo>|</mo>" + e.Left.mathML() + "<mo>|</mo></mrow>"
+
+	case "log":
+		base := "<mn>10</mn>"
+		if len(args) == 2 {
+			base = args[0].mathML()
+			args = args[1:]
+		}
+		name = "<msub><mi>log</mi>" + base + "</msub>"
+	}
+
+	// &#x2061; is function application, which is invisible, but tells
+	// screen readers that this isn't a multiplication
+	if bare && len(args) == 1 && e.bareFunc() {
+		return "<mrow>" + name + "<mo>&#x2061;</mo>" + args[0].mathML() + "</mrow>"
+	}
+	list := make([]string, len(args))
+	for i, arg := range args {
+		list[i] = arg.mathML()
+	}
+	return "<mrow>" + name + "<mo>&#x2061;</mo>" + mathmlBrackets(strings.Join(list, "<mo>,</mo>")) + "</mrow>"
+}
+
+// mathmlOperand writes out an operand of e, in brackets if it needs them
+func (e *Expression) mathmlOperand(operand *Expression, right bool) string {
+	if bracketed(e, operand, right) {
+		return mathmlBrackets(operand.mathML())
+	}
+	return operand.mathML()
+}
+
+func mathmlBrackets(s string) string {
+	return "<mrow><mo>(</mo>" + s + "<mo>)</mo></mrow>"
+}
+
+// bracketed reports whether operand needs brackets round it as part of e.
+// Values and functions never do, apart from a function with a factorial:
+// (sin x)! isn't sin(x!). The operands of an operator need them if they
+// bind less tightly than it, or for the right of - and = and the base of a
+// power, equally tightly. A negative needs them anywhere but the left of
+// +, -, * and either side of =, so a - (-b) doesn't look like a typo, and
+// so does anything else that starts with a - on the right. Anything else
+// goes in brackets when it's the argument of a function or a factorial.
+func bracketed(e, operand *Expression, right bool) bool {
+	if operand.isNegative() {
+		switch e.Type {
+		case EQUALS:
+			return false
+		case OP_LOW, OP_MED:
+			return right
+		}
+		return true
+	}
+	if ((right && e.Type != EQUALS) || e.Type == NEGATE) && startsNegative(operand) {
+		return true
+	}
+
+	switch operand.Type {
+	case NUMBER, CONSTANT, VARIABLE:
+		return false
+
+	case FUNC_PREFIX:
+		return e.Type == FUNC_POSTFIX
+
+	case OP_LOW, OP_MED, OP_HIGH, EQUALS:
+		switch e.Type {
+		case OP_LOW, OP_MED, OP_HIGH, EQUALS:
+			inner, outer := operand.printPower(), e.printPower()
+			if inner != outer {
+				return inner < outer
+			}
+			if right {
+				return e.Op == "-" || e.Type == EQUALS
+			}
+			return e.Op == "^"
+
+		case NEGATE:
+			return operand.Type == OP_LOW || operand.Type == EQUALS
+		}
+	}
+	return true
+}
+
+// visibleTimes reports whether the product e needs a ⋅ between its
+// operands, in the same places ToLatex writes a \cdot: before a number,
+// between a number and a fraction, and after a function without brackets.
+func (e *Expression) visibleTimes() bool {
+	if endsWithBareFunc(e.Left) {
+		return true
+	}
+	if bracketed(e, e.Right, true) {
+		return false
+	}
+	return startsWithNumber(e.Right) || (!bracketed(e, e.Left, false) && endsWithNumber(e.Left) && startsWithFraction(e.Right))
+}
+
+// startsWithNumber reports whether e is written starting with a number
+func startsWithNumber(e *Expression) bool {
+	switch e.Type {
+	case NUMBER:
+		return true
+	case OP_LOW, OP_MED, OP_HIGH, FUNC_POSTFIX:
+		return e.Op != "/" && !bracketed(e, e.Left, false) && startsWithNumber(e.Left)
+	}
+	return false
+}
+
+// endsWithNumber reports whether e is written ending with a number
+func endsWithNumber(e *Expression) bool {
+	switch e.Type {
+	case NUMBER:
+		return true
+	case NEGATE:
+		return !bracketed(e, e.Left, false) && endsWithNumber(e.Left)
+	case OP_LOW, OP_MED, EQUALS:
+		return e.Op != "/" && !bracketed(e, e.Right, true) && endsWithNumber(e.Right)
+	}
+	return false
+}
+
+// startsWithFraction reports whether e is written starting with a fraction
+func startsWithFraction(e *Expression) bool {
+	switch e.Type {
+	case OP_LOW, OP_MED, OP_HIGH, EQUALS, FUNC_POSTFIX:
+		return e.Op == "/" || (!bracketed(e, e.Left, false) && startsWithFraction(e.Left))
+	}
+	return false
+}
+
+// startsNegative reports whether e is written starting with a -
+func startsNegative(e *Expression) bool {
+	switch e.Type {
+	case NUMBER, NEGATE:
+		return e.isNegative()
+	case OP_LOW, OP_MED, EQUALS, FUNC_POSTFIX:
+		return e.Op != "/" && startsNegative(e.Left)
+	}
+	return false
+}
+
+// funcName gives the name a function's written with. The inverse trig
+// functions are arcsin and so on, and the inverse hyperbolic ones arsinh.
+func funcName(name string) string {
+	name = canonicalFunc(name)
+	if strings.HasPrefix(name, "a") && isTrigName(name[1:]) {
+		if strings.HasSuffix(name, "h") {
+			return "ar" + name[1:]
+		}
+		return "arc" + name[1:]
+	}
+	if name == "sign" {
+		return "sgn"
+	}
+	return name
+}
+
+// mathmlName writes out a variable name, splitting it up like latexName
+func mathmlName(name string) string {
+	base, sub := splitName(name)
+
+	if r, ok := greekNames[base]; ok {
+		base = string(r)
+	}
+	base = "<mi>" + html.EscapeString(base) + "</mi>"
+
+	switch {
+	case sub == "":
+		return base
+	case strings.Trim(sub, "0123456789") == "":
+		return "<msub>" + base + "<mn>" + sub + "</mn></msub>"
+	}
+	return "<msub>" + base + "<mi>" + html.EscapeString(sub) + "</mi></msub>"
+}
+
+// greekNames maps the names of the Greek letters back to the letters
+var greekNames = make(map[string]rune, len(greekRunes))
+
+func init() {
+	for r, name := range greekRunes {
+		greekNames[name] = r
+	}
+}
+
+// contentElements are the content MathML elements for the functions and
+// operators that have one
+var contentElements = map[string]string{
+	"+": "plus",
+	"-": "minus",
+	"*": "times",
+	"/": "divide",
+	"^": "power",
+	"!": "factorial",
+	"=": "eq",
+
+	"ln":   "ln",
+	"log":  "log",
+	"sqrt": "root",
+	"root": "root",
+	"abs":  "abs",
+	"min":  "min",
+	"max":  "max",
+}
+
+func init() {
+	for _, fn := range []string{"sin", "cos", "tan", "sec", "csc", "cot"} {
+		for _, h := range []string{"", "h"} {
+			contentElements[fn+h] = fn + h
+			contentElements["a"+fn+h] = "arc" + fn + h
+		}
+	}
+}
+
+// ToContentMathML writes the expression out as content MathML, which says
+// what it means rather than how it looks. ParseContentMathML reads it back
+// in.
+func (e *Expression) ToContentMathML() string {
+	return `<math xmlns="` + mathmlNamespace + `">` + e.contentMathML() + `</math>`
+}
+
+func (e *Expression) contentMathML() string {
+	op := e.Op
+
+	switch e.Type {
+	case NUMBER:
+		return "<cn>" + op + "</cn>"
+
+	case CONSTANT:
+		switch op {
+		case "pi":
+			return "<pi/>"
+		case "e":
+			return "<exponentiale/>"
+		case "i":
+			return "<imaginaryi/>"
+		}
+
+	case VARIABLE:
+		return "<ci>" + html.EscapeString(op) + "</ci>"
+
+	case FUNC_PREFIX:
+		args := e.Args()
+		name := canonicalFunc(op)
+
+		// The base of a log and the degree of a root are qualifiers, which
+		// come before the argument
+		var qualifier string
+		switch {
+		case name == "log" && len(args) == 2:
+			qualifier = "<logbase>" + args[0].contentMathML() + "</logbase>"
+			args = args[1:]
+		case name == "root":
+			qualifier = "<degree>" + args[0].contentMathML() + "</degree>"
+			args = args[1:]
+		}
+
+		head := `<ci type="function">` + html.EscapeString(op) + "</ci>"
+		if el, ok := contentElements[name]; ok {
+			head = "<" + el + "/>"
+		}
+		return contentApply(head+qualifier, args...)
+
+	case NEGATE:
+		return contentApply("<minus/>", e.Left)
+
+	case OP_LOW, OP_MED, OP_HIGH, FUNC_POSTFIX, EQUALS:
+		if el, ok := contentElements[op]; ok {
+			if e.Right == nil {
+				return contentApply("<"+el+"/>", e.Left)
+			}
+			return contentApply("<"+el+"/>", e.Left, e.Right)
+		}
+	}
+
+	return "<cerror><csymbol>" + html.EscapeString(op) + "</csymbol></cerror>"
+}
+
+func contentApply(head string, args ...*Expression) string {
+	s := "<apply>" + head
+	for _, arg := range args {
+		s += arg.contentMathML()
+	}
+	return s + "</apply>"
+}
+
+// A mathmlNode is an element of some MathML, for ParseContentMathML to
+// work through.
+type mathmlNode struct {
+	name     string
+	text     string
+	children []*mathmlNode
+	offset   int
+}
+
+func (n *mathmlNode) error(msg string) *ParseError {
+	return &ParseError{Offset: n.offset, Token: "<" + n.name + ">", Msg: msg}
+}
+
+// ParseContentMathML parses content MathML, like ToContentMathML writes,
+// into the same tree Parse gives for the equivalent plain expression. The
+// <math> element around it is optional.
+func ParseContentMathML(s string) (*Expression, error) {
+	root, err := readMathML(s)
+	var exp *Expression
+	if err == nil {
+		exp, err = root.content()
+	}
+	if err != nil {
+		if parseErr, ok := err.(*ParseError); ok {
+			parseErr.Input = s
+		}
+		return nil, err
+	}
+	return exp, nil
+}
+
+// readMathML reads the elements in s into a tree of mathmlNodes, returning
+// the one at the top.
+func readMathML(s string) (*mathmlNode, error) {
+	d := xml.NewDecoder(strings.NewReader(s))
+	stack := []*mathmlNode{{}}
+
+	for {
+		offset := int(d.InputOffset())
+		t, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, &ParseError{Offset: int(d.InputOffset()), Msg: err.Error()}
+		}
+
+		top := stack[len(stack)-1]
+		switch t := t.(type) {
+		case xml.StartElement:
+			n := &mathmlNode{name: t.Name.Local, offset: offset}
+			top.children = append(top.children, n)
+			stack = append(stack, n)
+
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+
+		case xml.CharData:
+			top.text += string(t)
+		}
+	}
+
+	doc := stack[0]
+	if len(doc.children) != 1 {
+		return nil, &ParseError{Offset: 0, Msg: "Expected one element"}
+	}
+	return doc.children[0], nil
+}
+
+// content turns the content MathML in n into an Expression
+func (n *mathmlNode) content() (*Expression, error) {
+	text := strings.TrimSpace(n.text)
+
+	switch n.name {
+	case "math", "semantics":
+		// semantics can have other representations after the first
+		if len(n.children) == 0 || (n.name == "math" && len(n.children) > 1) {
+			return nil, n.error("Expected one element")
+		}
+		return n.children[0].content()
+
+	case "cn":
+		// Only numbers Parse would read are allowed, which rules out NaN,
+		// Inf and hex floats
+		digits := strings.TrimPrefix(text, "-")
+		if digits == "" || !isDigit(digits[0]) || lexNumber(digits, 0) != len(digits) {
+			return nil, n.error("Invalid number: " + text)
+		}
+		if _, err := strconv.ParseFloat(digits, 64); err != nil {
+			return nil, n.error("Invalid number: " + text)
+		}
+		// Numbers from Parse are never negative: -2 is a negated 2
+		if digits != text {
+			return &Expression{"-", NEGATE, &Expression{digits, NUMBER, nil, nil}, nil}, nil
+		}
+		return &Expression{text, NUMBER, nil, nil}, nil
+
+	case "ci":
+		if text == "" {
+			return nil, n.error("Expected a name")
+		}
+		return &Expression{text, VARIABLE, nil, nil}, nil
+
+	case "pi":
+		return &Expression{"pi", CONSTANT, nil, nil}, nil
+
+	case "exponentiale":
+		return &Expression{"e", CONSTANT, nil, nil}, nil
+
+	case "imaginaryi":
+		return &Expression{"i", CONSTANT, nil, nil}, nil
+
+	case "apply":
+		return n.apply()
+	}
+
+	return nil, n.error("Unknown element")
+}
+
+// apply turns an <apply> into an Expression. The first child is what's
+// applied, and the rest are its qualifiers and arguments.
+func (n *mathmlNode) apply() (*Expression, error) {
+	if len(n.children) == 0 {
+		return nil, n.error("Expected a function")
+	}
+	head := n.children[0]
+
+	var qualifier *Expression
+	args := []*Expression{}
+	for _, child := range n.children[1:] {
+		switch child.name {
+		case "logbase", "degree":
+			if qualifier != nil || len(child.children) != 1 {
+				return nil, child.error("Unexpected qualifier")
+			}
+			q, err := child.children[0].content()
+			if err != nil {
+				return nil, err
+			}
+			qualifier = q
+			continue
+		}
+
+		arg, err := child.content()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+
+	if qualifier != nil && head.name != "root" && head.name != "log" {
+		return nil, head.error("Unexpected qualifier")
+	}
+
+	wrongArgs := head.error("Wrong number of arguments for " + head.name)
+	binary := func(typ uint8, op string) (*Expression, error) {
+		if len(args) != 2 {
+			return nil, wrongArgs
+		}
+		return &Expression{op, typ, args[0], args[1]}, nil
+	}
+
+	// + and * can take any number of arguments, and go left to right
+	chain := func(typ uint8, op string) (*Expression, error) {
+		if len(args) == 0 {
+			return nil, wrongArgs
+		}
+		e := args[0]
+		for _, arg := range args[1:] {
+			e = &Expression{op, typ, e, arg}
+		}
+		return e, nil
+	}
+
+	switch head.name {
+	case "plus":
+		return chain(OP_LOW, "+")
+	case "times":
+		return chain(OP_MED, "*")
+	case "minus":
+		if len(args) == 1 {
+			return &Expression{"-", NEGATE, args[0], nil}, nil
+		}
+		return binary(OP_LOW, "-")
+	case "divide":
+		return binary(OP_MED, "/")
+	case "power":
+		return binary(OP_HIGH, "^")
+	case "eq":
+		return binary(EQUALS, "=")
+
+	case "factorial":
+		if len(args) != 1 {
+			return nil, wrongArgs
+		}
+		return &Expression{"!", FUNC_POSTFIX, args[0], nil}, nil
+
+	case "root":
+		if qualifier == nil {
+			return contentFunc(head, "sqrt", args)
+		}
+		return contentFunc(head, "root", append([]*Expression{qualifier}, args...))
+
+	case "log":
+		if qualifier != nil {
+			args = append([]*Expression{qualifier}, args...)
+		}
+		return contentFunc(head, "log", args)
+
+	case "ci", "csymbol":
+		name := strings.TrimSpace(head.text)
+		if words[strings.ToLower(name)] != FUNC_PREFIX {
+			return nil, head.error("Unknown function: " + name)
+		}
+		return contentFunc(head, strings.ToLower(name), args)
+	}
+
+	if words[head.name] == FUNC_PREFIX {
+		return contentFunc(head, head.name, args)
+	}
+	return nil, head.error("Unknown function: " + head.name)
+}
+
+// contentFunc makes a call to the prefix function name, after checking
+// it's got the right number of args
+func contentFunc(head *mathmlNode, name string, args []*Expression) (*Expression, error) {
+	name = canonicalFunc(name)
+	least, most := funcArity(name)
+	if len(args) == 0 || len(args) < least || (most != -1 && len(args) > most) {
+		return nil, head.error("Wrong number of arguments for " + name)
+	}
+	return &Expression{name, FUNC_PREFIX, argList(args), nil}, nil
+}
@@ -0,0 +1,59 @@
+package algebra
+
+import "testing"
+
+func TestToMathMLBrackets(t *testing.T) {
+	for in, want := range map[string]string{
+		"sin(x)^2": "<msup><mrow><mi>sin</mi><mo>&#x2061;</mo><mrow><mo>(</mo><mi>x</mi><mo>)</mo></mrow></mrow><mrow><mn>2</mn></mrow></msup>",
+		"2*(1/3)":  "<mrow><mn>2</mn><mo>⋅</mo><mfrac><mn>1</mn><mn>3</mn></mfrac></mrow>",
+		"sin(x)*y": "<mrow><mrow><mi>sin</mi><mo>&#x2061;</mo><mi>x</mi></mrow><mo>⋅</mo><mi>y</mi></mrow>",
+		"2x":       "<mrow><mn>2</mn><mo>&#x2062;</mo><mi>x</mi></mrow>",
+		"x = -1":   "<mrow><mi>x</mi><mo>=</mo><mrow><mo>−</mo><mn>1</mn></mrow></mrow>",
+		"a - -b":   "<mrow><mi>a</mi><mo>−</mo><mrow><mo>(</mo><mrow><mo>−</mo><mi>b</mi></mrow><mo>)</mo></mrow></mrow>",
+	} {
+		e, err := Parse(in)
+		if err != nil {
+			t.Fatal(in, err)
+		}
+		if got := e.mathML(); got != want {
+			t.Errorf("%s:\n got %s\nwant %s", in, got, want)
+		}
+	}
+}
+
+func TestParseContentMathMLNumbers(t *testing.T) {
+	for in, want := range map[string]string{
+		"2":       "2",
+		"2.5e-3":  "2.5e-3",
+		"-2.5e-3": "-2.5e-3",
+	} {
+		e, err := ParseContentMathML("<cn>" + in + "</cn>")
+		if err != nil {
+			t.Errorf("%s: %v", in, err)
+		} else if got := e.Format(FormatOptions{}); got != want {
+			t.Errorf("%s: got %s want %s", in, got, want)
+		}
+	}
+
+	for _, in := range []string{"", "NaN", "Inf", "-Inf", "infinity", "0x1p3", "1e400", "+1", ".5", "1.", "1_000", "-"} {
+		if _, err := ParseContentMathML("<cn>" + in + "</cn>"); err == nil {
+			t.Errorf("%q: no error", in)
+		}
+	}
+}
+
+func TestContentMathMLRoundTrip(t *testing.T) {
+	for _, in := range roundTripCorpus {
+		e, err := Parse(in)
+		if err != nil {
+			t.Fatal(in, err)
+		}
+		mathml := e.ToContentMathML()
+		back, err := ParseContentMathML(mathml)
+		if err != nil {
+			t.Errorf("%s: %s: %v", in, mathml, err)
+		} else if !sameTree(e, back) {
+			t.Errorf("%s: %s reads back as %s", in, mathml, back.Format(FormatOptions{}))
+		}
+	}
+}
@@ -1,10 +1,9 @@
 package algebra
 
 import (
-	"errors"
-	"regexp"
+	"fmt"
 	"strings"
-	//"fmt"
+	"unicode/utf8"
 )
 
 // Token types:
@@ -19,337 +18,438 @@ import (
 // 8 - Medium precedence operator (*, /)
 // 9 - High precedence operator (^)
 // 10 - Equals
+// 12 - Comma (between function arguments)
+// 13 - Bar (either side of an absolute value)
 
+// Pos and End give the token's span in the input, which is where errors
+// get reported.
 type token struct {
 	Type  uint8
 	Value string
+	Pos   int
+	End   int
 }
 
-var precedence = []uint8{EQUALS, OP_LOW, OP_MED, OP_HIGH}
+// ParseError describes what went wrong parsing an expression, and where.
+type ParseError struct {
+	Input  string
+	Offset int
+	Token  string
+	Msg    string
+}
 
-func Parse(s string) (*Expression, error) {
-	s = strings.ToLower(s)
+func (e *ParseError) Error() string {
+	if e.Token == "" {
+		return fmt.Sprintf("%s at offset %d", e.Msg, e.Offset)
+	}
+	return fmt.Sprintf("%s at offset %d: '%s'", e.Msg, e.Offset, e.Token)
+}
 
-	tokens := tokenize(s)
+// Diagnostic gives the error message followed by the input, with a caret
+// under the problem, like:
+//
+//	Unmatched '(' at offset 3
+//	sin(x + 1
+//	   ^
+func (e *ParseError) Diagnostic() string {
+	offset := e.Offset
+	if offset > len(e.Input) {
+		offset = len(e.Input)
+	}
 
-	// Check that brackets are balanced:
-	depth := 0
-	for _, token := range tokens {
-		if token.Type == PAREN_OPEN {
-			depth++
-		} else if token.Type == PAREN_CLOSE {
-			depth--
+	// Keep any tabs so the caret lines up
+	indent := []rune{}
+	for _, r := range e.Input[:offset] {
+		if r != '\t' {
+			r = ' '
 		}
+		indent = append(indent, r)
 	}
 
-	if depth != 0 {
-		if depth < 0 {
-			return &Expression{}, errors.New("Unmatched ')'")
-		} else {
-			return &Expression{}, errors.New("Unmatched '('")
-		}
+	caret := "^"
+	if n := utf8.RuneCountInString(e.Token); n > 1 {
+		caret += strings.Repeat("~", n-1)
 	}
 
-	// Parse the thing!
-	return parseTokens(tokens)
+	return e.Error() + "\n" + e.Input + "\n" + string(indent) + caret
 }
 
-func parseTokens(tokens []token) (*Expression, error) {
-	// parseTokens will parse one operator, then call itself on the
-	// operands
-
-	tokens = debracketise(tokens)
-
-	// Just 1 token is probably a value on its own
-	if len(tokens) == 1 {
-		return &Expression{tokens[0].Value, tokens[0].Type, nil, nil}, nil
-	}
+// newParseError makes a ParseError about t, quoting it as it was written in
+// s, which might not be the same as its value: × for *, say.
+func newParseError(msg string, t token, s string) *ParseError {
+	return &ParseError{Offset: t.Pos, Token: s[t.Pos:t.End], Msg: msg}
+}
 
-	// search for operators outside of brackets
-	for _, op := range precedence {
-		depth := 0
-		for i := len(tokens) - 1; i >= 0; i-- {
-			skip := false
+// ParseOptions changes how ParseWithOptions reads expressions. The zero
+// value gives the same behaviour as Parse.
+type ParseOptions struct {
+	// Lenient skips over any characters that can't be part of a token,
+	// rather than reporting them as errors
+	Lenient bool
+
+	// Identifiers lets variable names be more than one letter long, like
+	// theta, Vmax, x1 or v_0, and keeps them in the case they were written
+	// in. Letters, digits and underscores all run together into one name,
+	// so xy is a single variable and x*y or x y is needed for a product.
+	// Function and constant names are still recognised in any case, unless
+	// CaseSensitive is set too.
+	Identifiers bool
+
+	// Symbols declares the variable names to look for when Identifiers is
+	// set. If there are any, runs of letters are split up into declared
+	// symbols, functions and constants (taking the longest that fits each
+	// time) and otherwise single letters, so with theta declared, xtheta
+	// is x*theta. Digits or an _ and what follows it after a variable are
+	// still its subscript.
+	Symbols []string
+
+	// CaseSensitive keeps variables and constants in the case they were
+	// written in, so M and m are different variables, and E is a variable
+	// rather than Euler's number. Function names are recognised in any
+	// case either way.
+	CaseSensitive bool
+}
 
-			if tokens[i].Type == PAREN_CLOSE {
-				depth++
-			} else if tokens[i].Type == PAREN_OPEN {
-				depth--
-			}
+func Parse(s string) (*Expression, error) {
+	return ParseWithOptions(s, ParseOptions{})
+}
 
-			// Handle implicit multiplication:
-			if op == 8 && i != 0 && depth == 0 {
-				prevType := tokens[i-1].Type
-				currentType := tokens[i].Type
-
-				switch prevType {
-				case NUMBER, CONSTANT, VARIABLE, PAREN_CLOSE: // implicit multiplication
-					switch currentType {
-					case NUMBER, FUNC_PREFIX, CONSTANT, VARIABLE, PAREN_OPEN:
-						left, err := parseTokens(tokens[:i])
-						if err != nil {
-							return nil, err
-						}
-						right, err := parseTokens(tokens[i:])
-						if err != nil {
-							return nil, err
-						}
-						return &Expression{"*", OP_MED, left, right}, nil
-					}
-				}
-			}
+func ParseWithOptions(s string, opts ParseOptions) (*Expression, error) {
+	return parseIn(s, opts, nil)
+}
 
-			// Process operators
-			if (tokens[i].Type == op) && (depth == 0) {
-				var (
-					left *Expression
-					err  error
-				)
-				// Handle unary '-'
-				if (op == 7) && (tokens[i].Value == "-") && ((i == 0) || (tokens[i-1].Type >= 7)) {
-					left = &Expression{"0", NUMBER, nil, nil}
-					if i != 0 {
-						skip = true
-					}
-				} else {
-					left, err = parseTokens(tokens[:i])
-					if err != nil {
-						return nil, err
-					}
-				}
-
-				if !skip {
-					right, err := parseTokens(tokens[i+1:])
-					if err != nil {
-						return nil, err
-					}
-
-					return &Expression{tokens[i].Value, tokens[i].Type, left, right}, nil
-				}
-			}
+// parseIn parses s with the extra words in sc, which can be nil.
+func parseIn(s string, opts ParseOptions, sc *scope) (*Expression, error) {
+	exp, err := parse(foldCase(s, opts), opts, sc)
+	if err != nil {
+		if parseErr, ok := err.(*ParseError); ok {
+			parseErr.Input = s
 		}
+		return exp, err
 	}
+	return exp, nil
+}
 
-	// prefix & postfix functions
-	for i := 1; i < len(tokens); i++ {
-		currentType := tokens[i].Type
-		prevType := tokens[i-1].Type
-
-		if prevType == FUNC_PREFIX {
-			left, err := parseTokens(tokens[i:])
-			if err != nil {
-				return nil, err
-			}
-			return &Expression{tokens[i-1].Value, prevType, left, nil}, nil
-		}
-
-		// postfix functions:
-		if currentType == FUNC_POSTFIX {
-			left, err := parseTokens(tokens[:i])
-			if err != nil {
-				return nil, err
-			}
-			return &Expression{tokens[i].Value, currentType, left, nil}, nil
+// foldCase lower cases the ASCII letters in s, unless opts say case
+// matters. Anything else is left alone, so Greek letters keep their case
+// and every character stays where it was for reporting errors.
+func foldCase(s string, opts ParseOptions) string {
+	if opts.Identifiers || opts.CaseSensitive {
+		return s
+	}
+	b := []byte(s)
+	for i, c := range b {
+		if c >= 'A' && c <= 'Z' {
+			b[i] = c + 'a' - 'A'
 		}
 	}
-
-	return nil, errors.New("Couldn't parse tokens")
+	return string(b)
 }
 
-func debracketise(tokens []token) []token {
-	if len(tokens) < 3 {
-		return tokens
+func parse(s string, opts ParseOptions, sc *scope) (*Expression, error) {
+	tokens, err := tokenize(s, opts, sc)
+	if err != nil {
+		return &Expression{}, err
 	}
-	depth := 0
-	hitsZero := false
-	for i, op := range tokens {
-		if op.Type == PAREN_OPEN {
-			depth++
-		} else if op.Type == PAREN_CLOSE {
-			depth--
-		}
+	return parseTokens(tokens, s, sc)
+}
 
-		if (depth == 0) && (i != len(tokens)-1) {
-			hitsZero = true
+// parseTokens parses tokens, which came from s
+func parseTokens(tokens []token, s string, sc *scope) (*Expression, error) {
+	// Check that brackets are balanced:
+	open := []token{}
+	for _, token := range tokens {
+		if token.Type == PAREN_OPEN {
+			open = append(open, token)
+		} else if token.Type == PAREN_CLOSE {
+			if len(open) == 0 {
+				return &Expression{}, newParseError("Unmatched ')'", token, s)
+			}
+			open = open[:len(open)-1]
 		}
 	}
 
-	if hitsZero {
-		return tokens
+	if len(open) != 0 {
+		return &Expression{}, newParseError("Unmatched '('", open[len(open)-1], s)
 	}
 
-	return tokens[1 : len(tokens)-1]
+	// Parse the thing!
+	p := &parser{tokens: tokens, input: s, scope: sc}
+	exp, err := p.parseExpression(bpNone)
+	if err != nil {
+		return nil, err
+	}
+	if t, ok := p.peek(); ok {
+		return nil, newParseError("Unexpected token", t, s)
+	}
+	return exp, nil
 }
 
-func tokenize(s string) []token {
-	matchers := []*regexp.Regexp{
-		regexp.MustCompile("([0-9]+)(\\.[0-9]+)?(e-?[0-9]+)?"),
-		regexp.MustCompile("((a(rc?)?)?(cosec|sin|cos|tan|sec|csc|cot)h?)|ln|log|sqrt"),
-		regexp.MustCompile("e|i|pi"),
-		regexp.MustCompile("[a-z]"),
-		regexp.MustCompile("\\("),
-		regexp.MustCompile("\\)"),
-		regexp.MustCompile("!"),
-		regexp.MustCompile("[+-]"),
-		regexp.MustCompile("[*/]"),
-		regexp.MustCompile("\\^"),
-		regexp.MustCompile("=")}
-
-	// order shows the order that tokens come in. -1 means that spot
-	// is empty, any other shows its position in the tokens array
-	order := make([]int, len(s))
-
-	for i := 0; i < cap(order); i++ {
-		order[i] = -1
-	}
+// Binding powers for the parser: the higher the number, the tighter an
+// operator holds on to its operands. Unary minus comes between * and ^, so
+// -x^2 is -(x^2) but -2x is (-2)*x. Prefix functions take just the next
+// value (and any postfix functions on it) as their argument, so sin x^2 is
+// (sin x)^2.
+const (
+	bpNone = iota
+	bpEquals
+	bpLow
+	bpMed
+	bpNeg
+	bpHigh
+	bpFunc
+	bpPostfix
+)
 
-	// List of tokens
-	tokens := make([]token, 0, 10)
+// parser is a Pratt parser: each token knows how to parse itself at the
+// start of an expression (values, brackets, prefix functions and unary
+// minus) and/or after one (binary operators and postfix functions), and
+// binding powers sort out precedence and associativity.
+type parser struct {
+	tokens []token
+	pos    int
 
-	// List of characters from the string that have been tokenized
-	tokenized := make([]bool, len(s))
+	// input is what the tokens came from, for errors
+	input string
 
-	//----------THE ACTUAL TOKENIZING BIT. WOOOOO!-----------//
+	// scope has the arities of any user defined functions
+	scope *scope
 
-	for i, match := range matchers {
-		// Find positions of all the things
-		nos := match.FindAllStringIndex(s, -1)
+	// bars is how many |s have been opened, and not closed yet, since the
+	// last bracket
+	bars int
+}
 
-		// Mark them as found and store their values
-		for _, n := range nos {
-			ignore := false
-			for i := n[0]; i < n[1]; i++ {
-				if tokenized[i] {
-					ignore = true
-				}
-				tokenized[i] = true
-			}
-			if !ignore {
-				order[n[0]] = len(tokens)
-				tokens = append(tokens, token{uint8(i), s[n[0]:n[1]]})
-			}
-		}
+func (p *parser) peek() (token, bool) {
+	if p.pos >= len(p.tokens) {
+		return token{}, false
 	}
+	return p.tokens[p.pos], true
+}
 
-	out := make([]token, 0, len(tokens))
-	for i := 0; i < len(s); i++ {
-		if order[i] != -1 {
-			out = append(out, tokens[order[i]])
-		}
+func (p *parser) next() (token, bool) {
+	t, ok := p.peek()
+	if ok {
+		p.pos++
 	}
-
-	return out
+	return t, ok
 }
 
-func (e *Expression) ToLatex() string {
-	opType, op := e.Type, e.Op
+// startsValue reports whether a token can only be the start of a new
+// value, so if it comes straight after one it's implicit multiplication,
+// like 2x or (x+1)(x-1).
+func startsValue(t token) bool {
+	switch t.Type {
+	case NUMBER, FUNC_PREFIX, CONSTANT, VARIABLE, PAREN_OPEN:
+		return true
+	}
+	return false
+}
 
-	switch opType {
-	case NUMBER, CONSTANT, VARIABLE:
-		if op == "pi" {
-			return " \\pi "
+// infixPower gives the binding power of t when it comes after a value, or
+// bpNone if it can't. A | after a value closes an absolute value if one's
+// open, and otherwise starts a new one that's multiplied by the value, so
+// |a|b|c| is |a|*b*|c|.
+func (p *parser) infixPower(t token) int {
+	switch t.Type {
+	case BAR:
+		if p.bars > 0 {
+			return bpNone
 		}
+		return bpMed
+	case EQUALS:
+		return bpEquals
+	case OP_LOW:
+		return bpLow
+	case OP_MED:
+		return bpMed
+	case OP_HIGH:
+		return bpHigh
+	case FUNC_POSTFIX:
+		return bpPostfix
+	}
 
-		return op
+	if startsValue(t) {
+		return bpMed
+	}
+	return bpNone
+}
 
-	case FUNC_PREFIX:
-		var arg string
-		switch e.Left.Type {
-			case NUMBER, CONSTANT, VARIABLE, FUNC_PREFIX:
-				arg = " " + e.Left.ToLatex() + " "
+// parseExpression parses everything from the current token on that binds
+// more tightly than rbp.
+func (p *parser) parseExpression(rbp int) (*Expression, error) {
+	left, err := p.parsePrefix()
+	if err != nil {
+		return nil, err
+	}
+	return p.parseInfixes(left, rbp)
+}
 
-			default:
-				arg = " \\left ( " + e.Left.ToLatex() + " \\right ) "
+// parseInfixes carries on from left, parsing any operators after it that
+// bind more tightly than rbp.
+func (p *parser) parseInfixes(left *Expression, rbp int) (*Expression, error) {
+	for {
+		t, ok := p.peek()
+		if !ok || p.infixPower(t) <= rbp {
+			return left, nil
 		}
-		switch op{
-			case "ln", "sin", "cos", "tan", "sec", "csc", "cot",
-			"sinh", "cosh", "tanh", "sech", "csch", "coth":
-				return " \\" + op + arg
 
-			case "cosec":
-				return " \\csc" + arg
+		var err error
+		left, err = p.parseInfix(left, t)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
 
-			case "asin", "arsin", "arcsin":
-				return " \\arcsin" + arg
+func (p *parser) parsePrefix() (*Expression, error) {
+	t, ok := p.next()
+	if !ok {
+		return nil, &ParseError{Offset: len(p.input), Msg: "Expected an expression"}
+	}
 
-			case "acos", "arcos", "arccos":
-				return " \\arccos" + arg
+	switch t.Type {
+	case NUMBER, CONSTANT, VARIABLE:
+		return &Expression{t.Value, t.Type, nil, nil}, nil
+
+	case PAREN_OPEN:
+		bars := p.bars
+		p.bars = 0
+		exp, err := p.parseExpression(bpNone)
+		p.bars = bars
+		if err != nil {
+			return nil, err
+		}
+		close, ok := p.next()
+		if !ok {
+			return nil, newParseError("Unmatched '('", t, p.input)
+		}
+		if close.Type != PAREN_CLOSE {
+			return nil, newParseError("Unexpected token", close, p.input)
+		}
+		return exp, nil
 
-			case "atan", "artan", "arctan":
-				return " \\arctan" + arg
+	case FUNC_PREFIX:
+		return p.parseFunction(t)
+
+	case BAR:
+		p.bars++
+		exp, err := p.parseExpression(bpNone)
+		p.bars--
+		if err != nil {
+			return nil, err
+		}
+		if close, ok := p.next(); !ok || close.Type != BAR {
+			return nil, newParseError("Unmatched '|'", t, p.input)
+		}
+		return &Expression{"abs", FUNC_PREFIX, exp, nil}, nil
 
-			case "asec", "arsec", "arcsec":
-				return " \\arcsec" + arg
+	case OP_LOW:
+		arg, err := p.parseExpression(bpNeg)
+		if err != nil {
+			return nil, err
+		}
+		if t.Value == "+" {
+			return arg, nil
+		}
+		return &Expression{"-", NEGATE, arg, nil}, nil
+	}
 
-			case "acsc", "arcsc", "arccsc", "acosec", "arcosec", "arccosec":
-				return " \\arccsc" + arg
+	return nil, newParseError("Expected an expression", t, p.input)
+}
 
-			case "acot", "arcot", "arccot":
-				return " \\arccot" + arg
+// parseFunction parses the arguments of the prefix function t. If they're
+// in brackets there can be several of them, separated by commas, otherwise
+// it's just the next value.
+func (p *parser) parseFunction(t token) (*Expression, error) {
+	next, ok := p.peek()
+	if !ok {
+		return nil, &ParseError{Offset: t.End, Msg: "Expected an argument for " + t.Value}
+	}
 
-			case "log":
-				return " \\log_{10} " + arg
+	var args []*Expression
+	if next.Type == PAREN_OPEN {
+		p.next()
+		bars := p.bars
+		p.bars = 0
+		for {
+			arg, err := p.parseExpression(bpNone)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
 
-			case "sqrt":
-				return " \\sqrt{" + e.Left.ToLatex() + "} "
+			sep, ok := p.next()
+			if !ok {
+				return nil, newParseError("Unmatched '('", next, p.input)
+			}
+			if sep.Type == PAREN_CLOSE {
+				break
+			}
+			if sep.Type != COMMA {
+				return nil, newParseError("Unexpected token", sep, p.input)
+			}
 		}
+		p.bars = bars
 
-	case FUNC_POSTFIX:
-		var arg string
-		switch e.Left.Type {
-			case NUMBER, CONSTANT, VARIABLE, FUNC_PREFIX:
-				arg = " " + e.Left.ToLatex() + " "
-
-			default:
-				arg = " \\left ( " + e.Left.ToLatex() + " \\right ) "
+		// A single bracketed argument is just a value like any other, so
+		// postfix functions go on it rather than the function: sin(x)! is
+		// sin(x!). Powers bind less tightly, so sin(x)^2 is (sin x)^2.
+		if len(args) == 1 {
+			arg, err := p.parseInfixes(args[0], bpFunc)
+			if err != nil {
+				return nil, err
+			}
+			args[0] = arg
 		}
-
-		return arg + op + " "
-
-	case OP_LOW, OP_MED, OP_HIGH:
-		var left, right string
-
-		if op == "/" {
-			left = " " + e.Left.ToLatex() + " "
-			right = " " + e.Right.ToLatex() + " "
-			return " \\frac{" + left + "}{" + right + "}"
+	} else {
+		arg, err := p.parseExpression(bpFunc)
+		if err != nil {
+			return nil, err
 		}
+		args = append(args, arg)
+	}
 
-		switch e.Left.Type {
-			case NUMBER, CONSTANT, VARIABLE, FUNC_PREFIX:
-				left = " " + e.Left.ToLatex() + " "
+	least, most := funcArity(t.Value)
+	if p.scope != nil {
+		if n, ok := p.scope.arities[t.Value]; ok {
+			least, most = n, n
+		}
+	}
+	if len(args) < least || (most != -1 && len(args) > most) {
+		return nil, newParseError("Wrong number of arguments for "+t.Value, t, p.input)
+	}
+	return &Expression{t.Value, FUNC_PREFIX, argList(args), nil}, nil
+}
 
-			case OP_LOW, OP_MED, OP_HIGH:
-				if e.Left.Type < opType {
-					left = " \\left ( " + e.Left.ToLatex() + " \\right ) "
-				} else {
-					left = " " + e.Left.ToLatex() + " "
-				}
+func (p *parser) parseInfix(left *Expression, t token) (*Expression, error) {
+	lbp := p.infixPower(t)
 
-			default:
-				left = " \\left ( " + e.Left.ToLatex() + " \\right ) "
-		}
-		switch e.Right.Type {
-			case NUMBER, CONSTANT, VARIABLE, FUNC_PREFIX:
-				right = " " + e.Right.ToLatex() + " "
-
-			case OP_LOW, OP_MED, OP_HIGH:
-				if e.Right.Type < opType {
-					right = " \\left ( " + e.Right.ToLatex() + " \\right ) "
-				} else {
-					right = " " + e.Right.ToLatex() + " "
-				}
-
-			default:
-				right = " \\left ( " + e.Right.ToLatex() + " \\right ) "
+	if startsValue(t) || t.Type == BAR {
+		right, err := p.parseExpression(bpMed)
+		if err != nil {
+			return nil, err
 		}
+		return &Expression{"*", OP_MED, left, right}, nil
+	}
 
-		if op == "*" {
-			op = ""
-		}
+	p.next()
 
-		return left + op + right
+	if t.Type == FUNC_POSTFIX {
+		return &Expression{t.Value, FUNC_POSTFIX, left, nil}, nil
 	}
 
-	return "MISSING: " + op
+	// Everything's left associative apart from ^, which has its right
+	// operand parsed with a slightly lower binding power so that another ^
+	// gets included in it
+	rbp := lbp
+	if t.Type == OP_HIGH {
+		rbp--
+	}
+
+	right, err := p.parseExpression(rbp)
+	if err != nil {
+		return nil, err
+	}
+	return &Expression{t.Value, t.Type, left, right}, nil
 }
@@ -0,0 +1,92 @@
+package algebra
+
+import (
+	"strings"
+	"testing"
+)
+
+var malformed = []struct {
+	input  string
+	offset int
+	token  string
+	msg    string
+}{
+	{"2 $ x", 2, "$", "Unrecognised character"},
+	{"x # y", 2, "#", "Unrecognised character"},
+	{"x & 2", 2, "&", "Unrecognised character"},
+	{"sin(x) ;", 7, ";", "Unrecognised character"},
+	{"x£", 1, "£", "Unrecognised character"},
+	{"2 + ?", 4, "?", "Unrecognised character"},
+	{"\"x\"", 0, "\"", "Unrecognised character"},
+	{"a[1]", 1, "[", "Unrecognised character"},
+	{"{x}", 0, "{", "Unrecognised character"},
+	{"1..2", 1, ".", "Unrecognised character"},
+
+	{"sin(x + 1", 3, "(", "Unmatched '('"},
+	{"((x)", 0, "(", "Unmatched '('"},
+	{"x + 1)", 5, ")", "Unmatched ')'"},
+	{"|x", 0, "|", "Unmatched '|'"},
+
+	{"", 0, "", "Expected an expression"},
+	{"2 +", 3, "", "Expected an expression"},
+	{"x^", 2, "", "Expected an expression"},
+	{"x = ", 4, "", "Expected an expression"},
+	{"(2+)*x", 3, ")", "Expected an expression"},
+	{"()", 1, ")", "Expected an expression"},
+	{"sin()", 4, ")", "Expected an expression"},
+	{"*2", 0, "*", "Expected an expression"},
+	{"2 * * 3", 4, "*", "Expected an expression"},
+	{"x = = 2", 4, "=", "Expected an expression"},
+	{"!x", 0, "!", "Expected an expression"},
+
+	{"sin", 3, "", "Expected an argument for sin"},
+	{"atan2(1)", 0, "atan2", "Wrong number of arguments for atan2"},
+	{"x,y", 1, ",", "Unexpected token"},
+
+	// Offsets are in bytes, and tokens are quoted as they were written
+	{"x ÷ $", 5, "$", "Unrecognised character"},
+	{"θ + ¿", 5, "¿", "Unrecognised character"},
+	{"2 × ", 5, "", "Expected an expression"},
+	{"2 − × 3", 6, "×", "Expected an expression"},
+	{"x ÷ ÷ y", 5, "÷", "Expected an expression"},
+	{"−", 3, "", "Expected an expression"},
+	{"x²)", 3, ")", "Unmatched ')'"},
+	{"(x × y", 0, "(", "Unmatched '('"},
+	{"√", 3, "", "Expected an argument for sqrt"},
+}
+
+func TestParseMalformed(t *testing.T) {
+	for _, test := range malformed {
+		_, err := Parse(test.input)
+		parseErr, ok := err.(*ParseError)
+		if !ok {
+			t.Errorf("%q: got %v, want a ParseError", test.input, err)
+			continue
+		}
+		if parseErr.Input != test.input || parseErr.Offset != test.offset || parseErr.Token != test.token || parseErr.Msg != test.msg {
+			t.Errorf("%q: got %q at %d (%q) in %q, want %q at %d (%q)", test.input,
+				parseErr.Msg, parseErr.Offset, parseErr.Token, parseErr.Input, test.msg, test.offset, test.token)
+		}
+		if !strings.HasPrefix(test.input[test.offset:], test.token) {
+			t.Errorf("%q: %q isn't at byte %d", test.input, test.token, test.offset)
+		}
+	}
+}
+
+func TestParseLenient(t *testing.T) {
+	for in, want := range map[string]string{
+		"2 $ x":    "2*x",
+		"x # y":    "x*y",
+		"sin(x) ;": "sin(x)",
+		"a[1]":     "a*1",
+		"{x}":      "x",
+		"x£ + 1":   "x + 1",
+	} {
+		e, err := ParseWithOptions(in, ParseOptions{Lenient: true})
+		if err != nil {
+			t.Errorf("%q: %v", in, err)
+		} else if got := e.Format(FormatOptions{}); got != want {
+			t.Errorf("%q: got %s want %s", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,336 @@
+package algebra
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// PrettyOptions control how Pretty draws an expression
+type PrettyOptions struct {
+	// Unicode draws with box drawing characters, Greek letters and proper
+	// minus signs, rather than plain ASCII.
+	Unicode bool
+}
+
+// Pretty draws the expression over several lines, the way it'd be written
+// by hand: fractions are stacked, powers are raised, square roots have a
+// sign over them, and brackets stretch to fit what's inside them. Like
+//
+//	   2
+//	  x
+//	-------
+//	 1 + x
+func (e *Expression) Pretty(opts PrettyOptions) string {
+	chars := asciiChars
+	if opts.Unicode {
+		chars = unicodeChars
+	}
+
+	b := e.pretty(chars)
+	lines := make([]string, len(b.lines))
+	for i, line := range b.lines {
+		lines[i] = strings.TrimRight(line, " ")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// prettyChars are the characters Pretty draws with
+type prettyChars struct {
+	minus, times, pi string
+
+	// rule is the line in a fraction, and bar is the sides of an absolute
+	// value
+	rule, bar string
+
+	// The top, middle and bottom of brackets taller than one line
+	parenLeft, parenRight [3]string
+
+	// The parts of a square root sign
+	slash, backslash, overline string
+
+	greek bool
+}
+
+var asciiChars = &prettyChars{
+	minus: "-", times: "*", pi: "pi",
+	rule: "-", bar: "|",
+	parenLeft:  [3]string{"/", "|", "\\"},
+	parenRight: [3]string{"\\", "|", "/"},
+	slash:      "/", backslash: "\\", overline: "_",
+}
+
+var unicodeChars = &prettyChars{
+	minus: "−", times: "⋅", pi: "π",
+	rule: "─", bar: "│",
+	parenLeft:  [3]string{"⎛", "⎜", "⎝"},
+	parenRight: [3]string{"⎞", "⎟", "⎠"},
+	slash:      "╱", backslash: "╲", overline: "_",
+	greek: true,
+}
+
+// A box is a rectangle of text. Every line is the same width, and the
+// baseline is the line that lines up with the boxes either side of it.
+type box struct {
+	lines    []string
+	baseline int
+}
+
+func textBox(s string) box {
+	return box{[]string{s}, 0}
+}
+
+func (b box) width() int {
+	return utf8.RuneCountInString(b.lines[0])
+}
+
+func (b box) height() int {
+	return len(b.lines)
+}
+
+// hjoin puts boxes side by side, lined up on their baselines
+func hjoin(boxes ...box) box {
+	above, below := 0, 0
+	for _, b := range boxes {
+		if b.baseline > above {
+			above = b.baseline
+		}
+		if n := b.height() - b.baseline - 1; n > below {
+			below = n
+		}
+	}
+
+	lines := make([]string, above+below+1)
+	for _, b := range boxes {
+		blank := strings.Repeat(" ", b.width())
+		for i := range lines {
+			line := blank
+			if j := i - above + b.baseline; j >= 0 && j < b.height() {
+				line = b.lines[j]
+			}
+			lines[i] += line
+		}
+	}
+	return box{lines, above}
+}
+
+// center pads each line of b to width w, keeping it in the middle
+func (b box) center(w int) box {
+	extra := w - b.width()
+	left, right := strings.Repeat(" ", extra/2), strings.Repeat(" ", extra-extra/2)
+	lines := make([]string, b.height())
+	for i, line := range b.lines {
+		lines[i] = left + line + right
+	}
+	return box{lines, b.baseline}
+}
+
+// fraction stacks num over den, with the line between them as the
+// baseline
+func fraction(num, den box, chars *prettyChars) box {
+	w := num.width()
+	if den.width() > w {
+		w = den.width()
+	}
+	w += 2
+
+	lines := append([]string{}, num.center(w).lines...)
+	lines = append(lines, strings.Repeat(chars.rule, w))
+	lines = append(lines, den.center(w).lines...)
+	return box{lines, num.height()}
+}
+
+// raise puts exp up and to the right of base, like a power
+func raise(base, exp box) box {
+	lines := make([]string, 0, exp.height()+base.height())
+	baseBlank, expBlank := strings.Repeat(" ", base.width()), strings.Repeat(" ", exp.width())
+	for _, line := range exp.lines {
+		lines = append(lines, baseBlank+line)
+	}
+	for _, line := range base.lines {
+		lines = append(lines, line+expBlank)
+	}
+	return box{lines, exp.height() + base.baseline}
+}
+
+// lower puts sub down and to the right of base, like a subscript
+func lower(base, sub box) box {
+	lines := make([]string, 0, base.height()+sub.height())
+	baseBlank, subBlank := strings.Repeat(" ", base.width()), strings.Repeat(" ", sub.width())
+	for _, line := range base.lines {
+		lines = append(lines, line+subBlank)
+	}
+	for _, line := range sub.lines {
+		lines = append(lines, baseBlank+line)
+	}
+	return box{lines, base.baseline}
+}
+
+// brackets puts b in brackets as tall as it is
+func brackets(b box, chars *prettyChars) box {
+	if b.height() == 1 {
+		return hjoin(textBox("("), b, textBox(")"))
+	}
+	return hjoin(sides(b, chars.parenLeft), b, sides(b, chars.parenRight))
+}
+
+// sides makes a column as tall as b, out of a top, middle and bottom
+func sides(b box, parts [3]string) box {
+	lines := make([]string, b.height())
+	for i := range lines {
+		switch i {
+		case 0:
+			lines[i] = parts[0]
+		case len(lines) - 1:
+			lines[i] = parts[2]
+		default:
+			lines[i] = parts[1]
+		}
+	}
+	return box{lines, b.baseline}
+}
+
+// radical draws a root sign over b, growing up and to the right from a
+// tick at the bottom, with index (if it's not empty) in the crook of it
+func radical(b box, index string, chars *prettyChars) box {
+	h := b.height()
+	content := hjoin(textBox(" "), b)
+
+	lines := []string{strings.Repeat(" ", h+1) + strings.Repeat(chars.overline, content.width())}
+	for i, line := range content.lines {
+		var prefix string
+		if i == h-1 {
+			prefix = chars.backslash + chars.slash + strings.Repeat(" ", h-1)
+		} else {
+			prefix = strings.Repeat(" ", h-i) + chars.slash + strings.Repeat(" ", i)
+		}
+		lines = append(lines, prefix+line)
+	}
+	root := box{lines, b.baseline + 1}
+
+	if index == "" {
+		return root
+	}
+
+	// The index sits on the line above the tick
+	idx := box{make([]string, h+1), root.baseline}
+	pad := strings.Repeat(" ", utf8.RuneCountInString(index))
+	for i := range idx.lines {
+		idx.lines[i] = pad
+	}
+	idx.lines[h-1] = index
+	return hjoin(idx, root)
+}
+
+func (e *Expression) pretty(chars *prettyChars) box {
+	op := e.Op
+
+	switch e.Type {
+	case NUMBER:
+		if strings.HasPrefix(op, "-") {
+			return textBox(chars.minus + op[1:])
+		}
+		return textBox(op)
+
+	case CONSTANT:
+		if op == "pi" {
+			return textBox(chars.pi)
+		}
+		return textBox(op)
+
+	case VARIABLE:
+		base, sub := splitName(op)
+		if r, ok := greekNames[base]; ok && chars.greek {
+			base = string(r)
+		}
+		if sub == "" {
+			return textBox(base)
+		}
+		return lower(textBox(base), textBox(sub))
+
+	case FUNC_PREFIX:
+		args := e.Args()
+		switch canonicalFunc(op) {
+		case "sqrt":
+			return radical(args[0].pretty(chars), "", chars)
+
+		case "root":
+			// Only a simple index fits in the root sign
+			if args[0].Type == NUMBER || args[0].Type == VARIABLE {
+				return radical(args[1].pretty(chars), args[0].Op, chars)
+			}
+
+		case "abs":
+			x := args[0].pretty(chars)
+			bar := sides(x, [3]string{chars.bar, chars.bar, chars.bar})
+			return hjoin(bar, x, bar)
+		}
+
+		list := []box{}
+		for i, arg := range args {
+			if i > 0 {
+				list = append(list, textBox(", "))
+			}
+			list = append(list, arg.pretty(chars))
+		}
+		return hjoin(textBox(op), brackets(hjoin(list...), chars))
+
+	case NEGATE:
+		// A minus right up against a fraction would run into its line
+		x := e.prettyOperand(e.Left, false, chars)
+		if x.height() > 1 {
+			return hjoin(textBox(chars.minus+" "), x)
+		}
+		return hjoin(textBox(chars.minus), x)
+
+	case FUNC_POSTFIX:
+		return hjoin(e.prettyOperand(e.Left, false, chars), textBox(op))
+
+	case OP_LOW, OP_MED, OP_HIGH, EQUALS:
+		switch op {
+		case "/":
+			return fraction(e.Left.pretty(chars), e.Right.pretty(chars), chars)
+
+		case "^":
+			return raise(e.prettyOperand(e.Left, false, chars), e.Right.pretty(chars))
+
+		case "*":
+			// A number in front of a letter doesn't need anything between
+			// them: 2x
+			left, right := e.prettyOperand(e.Left, false, chars), e.prettyOperand(e.Right, true, chars)
+			if e.Left.Type == NUMBER && !e.Left.isNegative() && startsWithLetter(e.Right) {
+				return hjoin(left, right)
+			}
+			op = chars.times
+
+		case "-":
+			op = chars.minus
+		}
+		return hjoin(e.prettyOperand(e.Left, false, chars), textBox(" "+op+" "), e.prettyOperand(e.Right, true, chars))
+
+	case COMMA:
+		return hjoin(e.Left.pretty(chars), textBox(", "), e.Right.pretty(chars))
+	}
+
+	return textBox(op)
+}
+
+// prettyOperand draws an operand of e, in brackets if it needs them
+func (e *Expression) prettyOperand(operand *Expression, right bool, chars *prettyChars) box {
+	b := operand.pretty(chars)
+	if bracketed(e, operand, right) {
+		return brackets(b, chars)
+	}
+	return b
+}
+
+// startsWithLetter reports whether e is drawn starting with a letter, not
+// counting anything that's raised or in a fraction
+func startsWithLetter(e *Expression) bool {
+	switch e.Type {
+	case CONSTANT, VARIABLE, FUNC_PREFIX:
+		return true
+	case OP_HIGH:
+		return startsWithLetter(e.Left) && !bracketed(e, e.Left, false)
+	}
+	return false
+}
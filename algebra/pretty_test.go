@@ -0,0 +1,207 @@
+package algebra
+
+import (
+	"strings"
+	"testing"
+)
+
+var prettyGolden = []struct {
+	in, ascii, unicode string
+}{
+	// Fractions are centred over each other, with a space either side
+	{"x^2/(1 + x)", `
+   2
+  x
+-------
+ 1 + x`, `
+   2
+  x
+───────
+ 1 + x`},
+
+	// With an odd space to share, the extra goes on the right
+	{"1/(x + 22)", `
+   1
+--------
+ x + 22`, `
+   1
+────────
+ x + 22`},
+
+	// The rest of the line lines up with the fraction's line
+	{"a = b/c", `
+     b
+a = ---
+     c`, `
+     b
+a = ───
+     c`},
+
+	{"(1/2)/(3/4)", `
+  1
+ ---
+  2
+-----
+  3
+ ---
+  4`, `
+  1
+ ───
+  2
+─────
+  3
+ ───
+  4`},
+
+	// Powers are raised above the whole of their base, which is the
+	// baseline, even when the power is a fraction itself
+	{"x^-1", `
+ -1
+x`, `
+ −1
+x`},
+	{"e^(x^2/2)", `
+   2
+  x
+ ----
+  2
+e`, `
+   2
+  x
+ ────
+  2
+e`},
+
+	// Brackets stretch to the height of what's in them
+	{"((x+1)/2)^2", `
+         2
+/ x + 1 \
+|-------|
+\   2   /`, `
+         2
+⎛ x + 1 ⎞
+⎜───────⎟
+⎝   2   ⎠`},
+	{"atan2(1/x, y)", `
+     / 1    \
+atan2|---, y|
+     \ x    /`, `
+     ⎛ 1    ⎞
+atan2⎜───, y⎟
+     ⎝ x    ⎠`},
+	{"(a/b)!", `
+/ a \
+|---|!
+\ b /`, `
+⎛ a ⎞
+⎜───⎟!
+⎝ b ⎠`},
+	{"abs(x/y)", `
+| x |
+|---|
+| y |`, `
+│ x │
+│───│
+│ y │`},
+
+	// Roots grow up to the height of what's under them
+	{"sqrt(x + 1)", `
+  ______
+\/ x + 1`, `
+  ______
+╲╱ x + 1`},
+	{"sqrt(1/(x+2))", `
+    ________
+   /    1
+  /  -------
+\/    x + 2`, `
+    ________
+   ╱    1
+  ╱  ───────
+╲╱    x + 2`},
+	{"root(3, x/2)", `
+     ____
+    /  x
+3  /  ---
+ \/    2`, `
+     ____
+    ╱  x
+3  ╱  ───
+ ╲╱    2`},
+
+	// A minus doesn't run into a fraction's line
+	{"-(a/b)", `
+   a
+- ---
+   b`, `
+   a
+− ───
+   b`},
+
+	// Things on one line
+	{"2x + 3sin(x)", `
+2x + 3sin(x)`, `
+2x + 3sin(x)`},
+	{"θ^2 - pi*x", `
+     2
+theta  - pi * x`, `
+ 2
+θ  − π ⋅ x`},
+	{"x = -1", `
+x = -1`, `
+x = −1`},
+}
+
+func TestPretty(t *testing.T) {
+	for _, test := range prettyGolden {
+		e, err := Parse(test.in)
+		if err != nil {
+			t.Fatal(test.in, err)
+		}
+		if got, want := e.Pretty(PrettyOptions{}), test.ascii[1:]; got != want {
+			t.Errorf("%s: got\n%s\nwant\n%s", test.in, got, want)
+		}
+		if got, want := e.Pretty(PrettyOptions{Unicode: true}), test.unicode[1:]; got != want {
+			t.Errorf("%s: got\n%s\nwant\n%s", test.in, got, want)
+		}
+	}
+}
+
+func TestPrettySubscript(t *testing.T) {
+	x := &Expression{"x_1", VARIABLE, nil, nil}
+	e := &Expression{"+", OP_LOW, &Expression{"^", OP_HIGH, x, no("2")}, &Expression{"theta0", VARIABLE, nil, nil}}
+	want := "  2\nx   + θ\n 1     0"
+	if got := e.Pretty(PrettyOptions{Unicode: true}); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestPrettyBoxes(t *testing.T) {
+	tall := box{[]string{"a", "b", "c"}, 1}
+	for _, test := range []struct {
+		name            string
+		b               box
+		width, baseline int
+		lines           string
+	}{
+		{"hjoin", hjoin(textBox("x"), tall, box{[]string{"p", "q"}, 0}), 3, 1, " a \nxbp\n cq"},
+		{"center", tall.center(4), 4, 1, " a  \n b  \n c  "},
+		{"fraction", fraction(tall, textBox("xy"), asciiChars), 4, 3, " a  \n b  \n c  \n----\n xy "},
+		{"raise", raise(tall, textBox("2")), 2, 2, " 2\na \nb \nc "},
+		{"lower", lower(tall, textBox("2")), 2, 1, "a \nb \nc \n 2"},
+		{"brackets", brackets(tall, unicodeChars), 3, 1, "⎛a⎞\n⎜b⎟\n⎝c⎠"},
+		{"short brackets", brackets(textBox("x"), unicodeChars), 3, 0, "(x)"},
+		{"radical", radical(tall, "", asciiChars), 6, 2, "    __\n   / a\n  /  b\n\\/   c"},
+		{"radical index", radical(tall, "n", asciiChars), 7, 2, "     __\n    / a\nn  /  b\n \\/   c"},
+	} {
+		if got := test.b.width(); got != test.width {
+			t.Errorf("%s: width %d want %d", test.name, got, test.width)
+		}
+		if test.b.baseline != test.baseline {
+			t.Errorf("%s: baseline %d want %d", test.name, test.b.baseline, test.baseline)
+		}
+		if got := strings.Join(test.b.lines, "\n"); got != test.lines {
+			t.Errorf("%s: got\n%s\nwant\n%s", test.name, got, test.lines)
+		}
+	}
+}
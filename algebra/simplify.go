@@ -1,6 +1,7 @@
 package algebra
 
 import (
+  "math"
   "math/big"
   "strings"
 )
@@ -24,6 +25,14 @@ func (exp *Expression) Simplify() *Expression {
 
   op := exp.Op
 
+  if exp.Type == NEGATE {
+    // -(-x) -> x
+    if left.Type == NEGATE {
+      exp = left.Left
+    }
+    return exp
+  }
+
   switch op {
     case "+":
       if left.Type == NUMBER && left.Op == "0" {
@@ -66,6 +75,34 @@ func (exp *Expression) Simplify() *Expression {
       if left.Type == CONSTANT && left.Op == "e" {
         exp = &Expression{"1", NUMBER, nil, nil}
       }
+
+    case "abs":
+      // |-x| -> |x|
+      if left.Type == NEGATE {
+        left = left.Left
+        exp.Left = left
+      }
+
+      if left.Type == FUNC_PREFIX && left.Op == "abs" {
+        // ||x|| -> |x|
+        exp = left
+      } else if left.IsConstant() {
+        // constants with a known sign don't need the bars
+        if x, err := left.Eval(nil); err == nil {
+          if x >= 0 {
+            exp = left
+          } else {
+            exp = neg(left).Simplify()
+          }
+        }
+      }
+
+    case "sign":
+      if left.IsConstant() {
+        if x, err := left.Eval(nil); err == nil && !math.IsNaN(x) {
+          exp = ratToExp(big.NewRat(int64(sign(x)), 1))
+        }
+      }
   }
 
   return exp
@@ -92,6 +129,15 @@ func (exp *Expression) IsConstant() bool {
 }
 
 func (exp *Expression) GetConstantTree() *Expression {
+  if exp.Type == NEGATE {
+    exp.Left = exp.Left.GetConstantTree()
+    if exp.Left.isGaussian() {
+      z := exp.Left.getGaussian()
+      return gaussianToExp(newGaussian(z.re.Neg(z.re), z.im.Neg(z.im)))
+    }
+    return exp
+  }
+
   if exp.Left == nil || exp.Right == nil {
     if exp.Type == NUMBER {
       n, err := big.NewRat(1, 1).SetString(exp.Op)
@@ -115,36 +161,38 @@ func (exp *Expression) GetConstantTree() *Expression {
 
   switch opType {
     case OP_LOW, OP_MED, OP_HIGH:
-      if (left.Type == NUMBER || left.isFrac()) && (right.Type == NUMBER || right.isFrac()) {
-        n1 := left.getFrac()
-        n2 := right.getFrac()
+      if left.isGaussian() && right.isGaussian() {
+        z1 := left.getGaussian()
+        z2 := right.getGaussian()
 
         switch op {
           case "+":
-            n1.Add(n1, n2)
+            z1 = z1.add(z2)
 
           case "-":
-            n1.Sub(n1, n2)
+            z1 = z1.sub(z2)
 
           case "*":
-            n1.Mul(n1, n2)
+            z1 = z1.mul(z2)
 
           case "/":
-            n1.Quo(n1, n2)
+            if z2.isZero() {
+              return exp
+            }
+            z1 = z1.quo(z2)
 
           case "^":
-            if n2.IsInt() {
-              n := n2.Num()
-              a := n1.Num()
-              b := n1.Denom()
+            if z2.im.Sign() != 0 || !z2.re.IsInt() {
+              return exp
+            }
 
-              n1.SetFrac(a.Exp(a, n, big.NewInt(0)), b.Exp(b, n, big.NewInt(0)))
-            } else {
+            var ok bool
+            if z1, ok = z1.pow(z2.re.Num()); !ok {
               return exp
             }
         }
 
-        return ratToExp(n1)
+        return gaussianToExp(z1)
       }
   }
 